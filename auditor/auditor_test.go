@@ -25,6 +25,8 @@ const (
 	StackBName = "stackB"
 	AppAState  = "started"
 	AppBState  = "stopped"
+	MigratedAt = "2026-10-17T02:14:09Z"
+	AppASkip   = "app annotation: vendor certification pending"
 )
 
 var _ = Describe("Auditor", func() {
@@ -55,8 +57,8 @@ var _ = Describe("Auditor", func() {
 			result, err := a.Audit()
 			Expect(err).NotTo(HaveOccurred())
 
-			expectedResult := AppAPath + " " + StackAName + " " + AppAState + "\n" +
-				AppBPath + " " + StackBName + " " + AppBState + "\n"
+			expectedResult := AppAPath + " " + StackAName + " " + AppAState + " (excluded from migration: " + AppASkip + ")\n" +
+				AppBPath + " " + StackBName + " " + AppBState + " (migrated from " + StackAName + " at " + MigratedAt + ")\n"
 			Expect(result).To(Equal(expectedResult))
 		})
 
@@ -67,18 +69,21 @@ var _ = Describe("Auditor", func() {
 
 			var apps resources.Apps
 			apps = append(apps, resources.App{
-				Name:  AppAName,
-				Stack: StackAName,
-				Org:   OrgName,
-				Space: SpaceName,
-				State: AppAState,
+				Name:       AppAName,
+				Stack:      StackAName,
+				Org:        OrgName,
+				Space:      SpaceName,
+				State:      AppAState,
+				SkipReason: AppASkip,
 			},
 				resources.App{
-					Name:  AppBName,
-					Stack: StackBName,
-					Org:   OrgName,
-					Space: SpaceName,
-					State: AppBState,
+					Name:         AppBName,
+					Stack:        StackBName,
+					Org:          OrgName,
+					Space:        SpaceName,
+					State:        AppBState,
+					MigratedFrom: StackAName,
+					MigratedAt:   MigratedAt,
 				})
 
 			expectedResult, err := json.Marshal(&apps)
@@ -0,0 +1,142 @@
+package cf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/utils"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionAssignStack       = "assign_stack"
+	ActionSetDroplet        = "set_current_droplet"
+	ActionCreateDeployment  = "create_deployment"
+	ActionRestage           = "restage"
+	ActionStartApp          = "start_app"
+	ActionStopApp           = "stop_app"
+	ActionScaleProcess      = "scale_process"
+	ActionUpdateHealthCheck = "update_health_check"
+	ActionSaveSidecar       = "save_sidecar"
+	ActionUpdateMetadata    = "update_metadata"
+	ActionDeleteStack       = "delete_stack"
+)
+
+const (
+	AuditSuccess         = "success"
+	AuditFailure         = "failure"
+	AuditLogEnv          = "STACK_AUDITOR_AUDIT_LOG"
+	ErrorWritingAuditLog = "problem writing audit log %s: %v"
+)
+
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	API        string    `json:"api"`
+	Endpoint   string    `json:"endpoint"`
+	TargetGUID string    `json:"target_guid"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only JSON lines file recording every call that
+// changes the foundation, who made it and against which API
+type AuditLog struct {
+	Path  string
+	User  string
+	API   string
+	lines *utils.JSONLines
+}
+
+// DefaultAuditLogPath returns the path set in STACK_AUDITOR_AUDIT_LOG, or
+// the audit log kept next to the cf CLI config
+func DefaultAuditLogPath() (string, error) {
+	if path := os.Getenv(AuditLogEnv); path != "" {
+		return path, nil
+	}
+
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(home, ".cf", "stack-auditor", "audit.log"), nil
+}
+
+func OpenAuditLog(path, user, api string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	lines, err := utils.OpenJSONLines(path, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditLog{Path: path, User: user, API: api, lines: lines}, nil
+}
+
+// Record appends an entry for a call that has been made. A nil AuditLog
+// records nothing.
+func (l *AuditLog) Record(action, endpoint, targetGUID string, callErr error) error {
+	if l == nil {
+		return nil
+	}
+
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		User:       l.User,
+		API:        l.API,
+		Endpoint:   endpoint,
+		TargetGUID: targetGUID,
+		Action:     action,
+		Result:     AuditSuccess,
+	}
+	if callErr != nil {
+		entry.Result = AuditFailure
+		entry.Error = callErr.Error()
+	}
+
+	return l.lines.Append(entry)
+}
+
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.lines.Close()
+}
+
+// Audit records a call in the audit log. The call has already happened, so a
+// log that cannot be written is only reported.
+func (cf *CF) Audit(action, endpoint, targetGUID string, callErr error) {
+	if err := cf.AuditLog.Record(action, endpoint, targetGUID, callErr); err != nil {
+		fmt.Fprintf(os.Stderr, ErrorWritingAuditLog+"\n", cf.AuditLog.Path, err)
+	}
+}
+
+// Mutate curls a path that changes the foundation and records the call in
+// the audit log
+func (cf *CF) Mutate(action, targetGUID, path string, args ...string) ([]string, error) {
+	output, err := cf.CFCurl(path, args...)
+	cf.Audit(action, curlMethod(args)+" "+path, targetGUID, err)
+	return output, err
+}
+
+func curlMethod(args []string) string {
+	for i, arg := range args {
+		if arg == "-X" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if method, ok := strings.CutPrefix(arg, "-X="); ok {
+			return method
+		}
+	}
+	return "GET"
+}
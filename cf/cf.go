@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 
@@ -14,8 +16,13 @@ import (
 )
 
 type CF struct {
-	Conn  plugin.CliConnection
-	Space plugin_models.Space
+	Conn     plugin.CliConnection
+	Org      plugin_models.Organization
+	Space    plugin_models.Space
+	AuditLog *AuditLog
+
+	// connLock, once set, makes the copies of a CF sharing Conn take turns
+	connLock *sync.Mutex
 }
 
 var (
@@ -23,6 +30,32 @@ var (
 	V3ResultsPerPage = "5000"
 )
 
+// ErrNoCurrentDroplet is returned for an app that has no current droplet,
+// such as one that was never staged successfully
+var ErrNoCurrentDroplet = errors.New("no current droplet found")
+
+// resourceNotFoundCode is the v3 error code of a missing resource
+const resourceNotFoundCode = 10010
+
+// v3Error holds the errors returned by a v3 endpoint
+type v3Error struct {
+	codes   []int
+	details []string
+}
+
+func (e *v3Error) Error() string {
+	return strings.Join(e.details, ", ")
+}
+
+func (e *v3Error) notFound() bool {
+	for _, code := range e.codes {
+		if code == resourceNotFoundCode {
+			return true
+		}
+	}
+	return false
+}
+
 func (cf *CF) GetAppsAndStacks() (resources.Apps, error) {
 	var entries []resources.App
 
@@ -31,6 +64,12 @@ func (cf *CF) GetAppsAndStacks() (resources.Apps, error) {
 		return nil, err
 	}
 
+	spaceMetadata, err := cf.getAllSpaceMetadata()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
 	for _, appsJSON := range allApps {
 		for _, app := range appsJSON.Apps {
 			appName := app.Name
@@ -40,19 +79,44 @@ func (cf *CF) GetAppsAndStacks() (resources.Apps, error) {
 
 			orgName := orgMap[spaceOrgMap[app.Relationships.Space.Data.GUID]]
 			entries = append(entries, resources.App{
-				Space: spaceName,
-				Name:  appName,
-				Stack: stackName,
-				Org:   orgName,
-				State: state,
+				Space:        spaceName,
+				Name:         appName,
+				Stack:        stackName,
+				Org:          orgName,
+				State:        state,
+				MigratedFrom: app.Metadata.Label(resources.MigratedFromLabel),
+				MigratedAt:   app.Metadata.Annotation(resources.MigratedAtAnnotation),
+				SkipReason:   resources.SkipReason(app.Metadata, spaceMetadata[app.Relationships.Space.Data.GUID], now),
 			})
 		}
 	}
 	return entries, nil
 }
 
+// ShareConnection lets copies of cf made from now on call Conn at the same
+// time. The plugin's RPC connection collects the output of one command at a
+// time, so concurrent calls would get each other's output; they take turns
+// instead.
+func (cf *CF) ShareConnection() {
+	if cf.connLock == nil {
+		cf.connLock = &sync.Mutex{}
+	}
+}
+
+// lockConn waits for the turn of cf to call Conn and returns the function
+// that ends it
+func (cf *CF) lockConn() func() {
+	if cf.connLock == nil {
+		return func() {}
+	}
+	cf.connLock.Lock()
+	return cf.connLock.Unlock
+}
+
 func (cf *CF) GetStackGUID(stackName string) (string, error) {
+	unlock := cf.lockConn()
 	out, err := cf.Conn.CliCommandWithoutTerminalOutput("stack", "--guid", stackName)
+	unlock()
 	if err != nil {
 		return "", fmt.Errorf("failed to get GUID of %s", stackName)
 	}
@@ -113,6 +177,8 @@ func (cf *CF) getCFContext() (orgMap, spaceNameMap, spaceOrgMap map[string]strin
 }
 
 func (cf *CF) getOrgs() (resources.Orgs, error) {
+	unlock := cf.lockConn()
+	defer unlock()
 	return cf.Conn.GetOrgs()
 }
 
@@ -139,6 +205,41 @@ func (cf *CF) getAllSpaces() (resources.Spaces, error) {
 	return allSpaces, nil
 }
 
+// getAllSpaceMetadata returns the labels and annotations of every space by
+// space GUID
+func (cf *CF) getAllSpaceMetadata() (map[string]resources.Metadata, error) {
+	metadata := make(map[string]resources.Metadata)
+	nextURL := fmt.Sprintf("/v3/spaces?per_page=%s", V3ResultsPerPage)
+	for nextURL != "" {
+		spacesJSON, err := cf.CFCurl(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var spaces resources.V3SpacesJSON
+		if strings.Join(spacesJSON, "") == "" {
+			break
+		}
+
+		if err := json.Unmarshal([]byte(strings.Join(spacesJSON, "")), &spaces); err != nil {
+			return nil, fmt.Errorf("error unmarshaling spaces json: %v", err)
+		}
+		nextURL = spaces.Pagination.Next.Href
+		for _, space := range spaces.Spaces {
+			metadata[space.GUID] = space.Metadata
+		}
+	}
+	return metadata, nil
+}
+
+func (cf *CF) GetSpaceMetadata(spaceGUID string) (resources.Metadata, error) {
+	var space resources.V3Space
+	if err := cf.curlJSON("/v3/spaces/"+spaceGUID, "space", &space); err != nil {
+		return resources.Metadata{}, err
+	}
+	return space.Metadata, nil
+}
+
 func (cf *CF) GetAllApps() ([]resources.V3AppsJSON, error) {
 	var allApps []resources.V3AppsJSON
 	nextURL := fmt.Sprintf("/v3/apps?per_page=%s", V3ResultsPerPage)
@@ -183,6 +284,113 @@ func (cf *CF) GetAppByName(appName string) (resources.V3App, error) {
 	return app, nil
 }
 
+func (cf *CF) GetAppByGUID(appGUID string) (resources.V3App, error) {
+	var app resources.V3App
+
+	appJSON, err := cf.CFCurl("/v3/apps/" + appGUID)
+	if err != nil {
+		return app, err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(appJSON, "")), &app); err != nil {
+		return app, fmt.Errorf("error unmarshaling app json: %v", err)
+	}
+	if app.GUID == "" {
+		return app, fmt.Errorf("no app found with guid %s", appGUID)
+	}
+
+	return app, nil
+}
+
+// TargetCurrentSpace points app lookups at the space targeted by the CLI
+func (cf *CF) TargetCurrentSpace() error {
+	unlock := cf.lockConn()
+	defer unlock()
+
+	org, err := cf.Conn.GetCurrentOrg()
+	if err != nil {
+		return err
+	}
+
+	space, err := cf.Conn.GetCurrentSpace()
+	if err != nil {
+		return err
+	}
+
+	cf.Org = org
+	cf.Space = space
+	return nil
+}
+
+// GetCurrentSpace returns the space targeted by the CLI
+func (cf *CF) GetCurrentSpace() (plugin_models.Space, error) {
+	unlock := cf.lockConn()
+	defer unlock()
+	return cf.Conn.GetCurrentSpace()
+}
+
+// TargetSpace points app lookups at the named space without changing the
+// space targeted by the CLI
+func (cf *CF) TargetSpace(orgName, spaceName string) error {
+	var orgs resources.V3OrgsJSON
+	orgsJSON, err := cf.CFCurl(fmt.Sprintf("/v3/organizations?names=%s", url.QueryEscape(orgName)))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(orgsJSON, "")), &orgs); err != nil {
+		return fmt.Errorf("error unmarshaling orgs json: %v", err)
+	}
+	if len(orgs.Orgs) == 0 {
+		return fmt.Errorf("no org found with name %s", orgName)
+	}
+
+	var spaces resources.V3SpacesJSON
+	spacesJSON, err := cf.CFCurl(fmt.Sprintf("/v3/spaces?names=%s&organization_guids=%s", url.QueryEscape(spaceName), orgs.Orgs[0].GUID))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(spacesJSON, "")), &spaces); err != nil {
+		return fmt.Errorf("error unmarshaling spaces json: %v", err)
+	}
+	if len(spaces.Spaces) == 0 {
+		return fmt.Errorf("no space found with name %s in org %s", spaceName, orgName)
+	}
+
+	cf.setSpace(orgs.Orgs[0], spaces.Spaces[0])
+	return nil
+}
+
+// TargetSpaceByGUID points app lookups at the given space without changing
+// the space targeted by the CLI
+func (cf *CF) TargetSpaceByGUID(spaceGUID string) error {
+	var spaces resources.V3SpacesJSON
+	spacesJSON, err := cf.CFCurl(fmt.Sprintf("/v3/spaces?guids=%s&include=organization", spaceGUID))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(spacesJSON, "")), &spaces); err != nil {
+		return fmt.Errorf("error unmarshaling spaces json: %v", err)
+	}
+	if len(spaces.Spaces) == 0 || len(spaces.Included.Orgs) == 0 {
+		return fmt.Errorf("no space found with guid %s", spaceGUID)
+	}
+
+	cf.setSpace(spaces.Included.Orgs[0], spaces.Spaces[0])
+	return nil
+}
+
+func (cf *CF) setSpace(org resources.V3Org, space resources.V3Space) {
+	cf.Org = plugin_models.Organization{
+		OrganizationFields: plugin_models.OrganizationFields{Guid: org.GUID, Name: org.Name},
+	}
+	cf.Space = plugin_models.Space{
+		SpaceFields: plugin_models.SpaceFields{Guid: space.GUID, Name: space.Name},
+	}
+}
+
 func (cf *CF) GetAppInfo(appName string) (appGuid, appState, appStack string, err error) {
 	app, err := cf.GetAppByName(appName)
 	if err != nil {
@@ -192,6 +400,233 @@ func (cf *CF) GetAppInfo(appName string) (appGuid, appState, appStack string, er
 	return app.GUID, app.State, app.Lifecycle.Data.Stack, nil
 }
 
+func (cf *CF) GetCurrentDroplet(appGUID string) (resources.DropletJSON, error) {
+	var droplet resources.DropletJSON
+
+	dropletJSON, err := cf.CFCurl(fmt.Sprintf("/v3/apps/%s/droplets/current", appGUID))
+	var apiErr *v3Error
+	if errors.As(err, &apiErr) && apiErr.notFound() {
+		return droplet, fmt.Errorf("%w for app %s", ErrNoCurrentDroplet, appGUID)
+	}
+	if err != nil {
+		return droplet, err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(dropletJSON, "")), &droplet); err != nil {
+		return droplet, fmt.Errorf("error unmarshaling droplet json: %v", err)
+	}
+	if droplet.GUID == "" {
+		return droplet, fmt.Errorf("%w for app %s", ErrNoCurrentDroplet, appGUID)
+	}
+
+	return droplet, nil
+}
+
+// GetStagedDroplets returns the app's staged droplets, newest first
+func (cf *CF) GetStagedDroplets(appGUID string) ([]resources.DropletJSON, error) {
+	var allDroplets []resources.DropletJSON
+	nextURL := fmt.Sprintf("/v3/apps/%s/droplets?states=STAGED&order_by=-created_at&per_page=%s", appGUID, V3ResultsPerPage)
+	for nextURL != "" {
+		dropletsJSON, err := cf.CFCurl(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var droplets resources.DropletListJSON
+		if strings.Join(dropletsJSON, "") == "" {
+			break
+		}
+
+		if err := json.Unmarshal([]byte(strings.Join(dropletsJSON, "")), &droplets); err != nil {
+			return nil, fmt.Errorf("error unmarshaling droplets json: %v", err)
+		}
+		nextURL = droplets.Pagination.Next.Href
+		allDroplets = append(allDroplets, droplets.Resources...)
+	}
+	return allDroplets, nil
+}
+
+func (cf *CF) GetAppProcesses(appGUID string) ([]resources.Process, error) {
+	var allProcesses []resources.Process
+	nextURL := fmt.Sprintf("/v3/apps/%s/processes?per_page=%s", appGUID, V3ResultsPerPage)
+	for nextURL != "" {
+		processesJSON, err := cf.CFCurl(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var processes resources.ProcessesJSON
+		if strings.Join(processesJSON, "") == "" {
+			break
+		}
+
+		if err := json.Unmarshal([]byte(strings.Join(processesJSON, "")), &processes); err != nil {
+			return nil, fmt.Errorf("error unmarshaling processes json: %v", err)
+		}
+		nextURL = processes.Pagination.Next.Href
+		allProcesses = append(allProcesses, processes.Processes...)
+	}
+	return allProcesses, nil
+}
+
+func (cf *CF) GetAppSidecars(appGUID string) ([]resources.Sidecar, error) {
+	var allSidecars []resources.Sidecar
+	nextURL := fmt.Sprintf("/v3/apps/%s/sidecars?per_page=%s", appGUID, V3ResultsPerPage)
+	for nextURL != "" {
+		sidecarsJSON, err := cf.CFCurl(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var sidecars resources.SidecarsJSON
+		if strings.Join(sidecarsJSON, "") == "" {
+			break
+		}
+
+		if err := json.Unmarshal([]byte(strings.Join(sidecarsJSON, "")), &sidecars); err != nil {
+			return nil, fmt.Errorf("error unmarshaling sidecars json: %v", err)
+		}
+		nextURL = sidecars.Pagination.Next.Href
+		allSidecars = append(allSidecars, sidecars.Sidecars...)
+	}
+	return allSidecars, nil
+}
+
+func (cf *CF) GetProcessStats(processGUID string) ([]resources.ProcessInstanceStats, error) {
+	var stats resources.ProcessStatsJSON
+
+	statsJSON, err := cf.CFCurl(fmt.Sprintf("/v3/processes/%s/stats", processGUID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(statsJSON, "")), &stats); err != nil {
+		return nil, fmt.Errorf("error unmarshaling process stats json: %v", err)
+	}
+
+	return stats.Stats, nil
+}
+
+// GetLatestPackage returns the app's newest package, which a restage stages
+// from, or nil if the app has no package
+func (cf *CF) GetLatestPackage(appGUID string) (*resources.Package, error) {
+	var packages resources.PackagerJSON
+
+	packagesJSON, err := cf.CFCurl(fmt.Sprintf("/v3/apps/%s/packages?order_by=-created_at&per_page=1", appGUID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(packagesJSON, "")), &packages); err != nil {
+		return nil, fmt.Errorf("error unmarshaling packages json: %v", err)
+	}
+	if len(packages.Resources) == 0 {
+		return nil, nil
+	}
+
+	return &packages.Resources[0], nil
+}
+
+func (cf *CF) GetActiveDeployments(appGUID string) ([]resources.Deployment, error) {
+	var deployments resources.DeploymentsJSON
+
+	deploymentsJSON, err := cf.CFCurl(fmt.Sprintf("/v3/deployments?app_guids=%s&status_values=ACTIVE", appGUID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(deploymentsJSON, "")), &deployments); err != nil {
+		return nil, fmt.Errorf("error unmarshaling deployments json: %v", err)
+	}
+
+	return deployments.Deployments, nil
+}
+
+// GetStagingBuilds returns the app's builds that are still staging, such as
+// those of a push in progress
+func (cf *CF) GetStagingBuilds(appGUID string) ([]resources.Build, error) {
+	var builds resources.BuildsJSON
+	if err := cf.curlJSON(fmt.Sprintf("/v3/builds?app_guids=%s&states=STAGING", appGUID), "builds", &builds); err != nil {
+		return nil, err
+	}
+	return builds.Builds, nil
+}
+
+// GetStagedBuilds returns up to limit of the app's most recent successful
+// builds, newest first
+func (cf *CF) GetStagedBuilds(appGUID string, limit int) ([]resources.Build, error) {
+	var builds resources.BuildsJSON
+	if err := cf.curlJSON(fmt.Sprintf("/v3/builds?app_guids=%s&states=STAGED&order_by=-created_at&per_page=%d", appGUID, limit), "builds", &builds); err != nil {
+		return nil, err
+	}
+	return builds.Builds, nil
+}
+
+// GetMemoryQuotas returns the memory limit and usage of the space's quota,
+// if it has one, and of its organization's quota
+func (cf *CF) GetMemoryQuotas(spaceGUID string) ([]resources.MemoryQuota, error) {
+	var space resources.V3Space
+	if err := cf.curlJSON("/v3/spaces/"+spaceGUID, "space", &space); err != nil {
+		return nil, err
+	}
+
+	var quotas []resources.MemoryQuota
+	if space.Relationships.Quota.Data != nil {
+		quota, err := cf.getMemoryQuota("space", "/v3/space_quotas/"+space.Relationships.Quota.Data.GUID, "/v3/spaces/"+spaceGUID+"/usage_summary")
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+
+	orgGUID := space.Relationships.Organization.Data.GUID
+	var org resources.V3Org
+	if err := cf.curlJSON("/v3/organizations/"+orgGUID, "org", &org); err != nil {
+		return nil, err
+	}
+
+	if org.Relationships.Quota.Data != nil {
+		quota, err := cf.getMemoryQuota("org", "/v3/organization_quotas/"+org.Relationships.Quota.Data.GUID, "/v3/organizations/"+orgGUID+"/usage_summary")
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+
+	return quotas, nil
+}
+
+func (cf *CF) getMemoryQuota(kind, quotaPath, usagePath string) (resources.MemoryQuota, error) {
+	var quota resources.V3Quota
+	if err := cf.curlJSON(quotaPath, "quota", &quota); err != nil {
+		return resources.MemoryQuota{}, err
+	}
+
+	var usage resources.UsageSummaryJSON
+	if err := cf.curlJSON(usagePath, "usage summary", &usage); err != nil {
+		return resources.MemoryQuota{}, err
+	}
+
+	return resources.MemoryQuota{
+		Kind:      kind,
+		Name:      quota.Name,
+		LimitInMB: quota.Apps.TotalMemoryInMB,
+		UsedInMB:  usage.UsageSummary.MemoryInMB,
+	}, nil
+}
+
+func (cf *CF) curlJSON(path, name string, v interface{}) error {
+	out, err := cf.CFCurl(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(strings.Join(out, "")), v); err != nil {
+		return fmt.Errorf("error unmarshaling %s json: %v", name, err)
+	}
+	return nil
+}
+
 func (cf *CF) CFCurl(path string, args ...string) ([]string, error) {
 	u, err := url.Parse(path)
 	if err != nil {
@@ -202,7 +637,9 @@ func (cf *CF) CFCurl(path string, args ...string) ([]string, error) {
 
 	curlArgs := []string{"curl", u.String()}
 	curlArgs = append(curlArgs, args...)
+	unlock := cf.lockConn()
 	output, err := cf.Conn.CliCommandWithoutTerminalOutput(curlArgs...)
+	unlock()
 	if err != nil {
 		return nil, err
 	}
@@ -242,10 +679,11 @@ func checkV3Error(lines []string) error {
 
 	}
 
-	errorDetails := make([]string, 0)
+	apiErr := &v3Error{details: make([]string, 0)}
 	for _, e := range errorsJSON.Errors {
-		errorDetails = append(errorDetails, e.Detail)
+		apiErr.codes = append(apiErr.codes, e.Code)
+		apiErr.details = append(apiErr.details, e.Detail)
 	}
 
-	return errors.New(strings.Join(errorDetails, ", "))
+	return apiErr
 }
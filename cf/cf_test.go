@@ -2,6 +2,7 @@ package cf_test
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/cloudfoundry/stack-auditor/cf"
 
@@ -37,6 +38,19 @@ var _ = Describe("CF", func() {
 		})
 	})
 
+	When("finding the audit log", func() {
+		It("uses the path set in the environment", func() {
+			GinkgoT().Setenv(cf.AuditLogEnv, "/some/audit.log")
+			Expect(cf.DefaultAuditLogPath()).To(Equal("/some/audit.log"))
+		})
+
+		It("keeps the audit log next to the cf CLI config", func() {
+			GinkgoT().Setenv(cf.AuditLogEnv, "")
+			GinkgoT().Setenv("CF_HOME", "/some/home")
+			Expect(cf.DefaultAuditLogPath()).To(Equal(filepath.Join("/some/home", ".cf", "stack-auditor", "audit.log")))
+		})
+	})
+
 	When("CFCurl", func() {
 		It("performs a successful CF curl", func() {
 			mockOutput, err := mocks.FileToString("apps.json")
@@ -101,4 +115,59 @@ var _ = Describe("CF", func() {
 			})
 		})
 	})
+
+	When("TargetSpace", func() {
+		It("looks up the org and space without changing the CLI target", func() {
+			orgs, err := mocks.FileToString("orgsV3.json")
+			Expect(err).ToNot(HaveOccurred())
+			spaces, err := mocks.FileToString("spacesV3.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations?names=orgB").Return(orgs, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces?names=otherSpace&organization_guids=orgBGuid").Return(spaces, nil)
+
+			Expect(c.TargetSpace("orgB", "otherSpace")).To(Succeed())
+			Expect(c.Org.Guid).To(Equal("orgBGuid"))
+			Expect(c.Space.Guid).To(Equal("otherSpaceGuid"))
+			Expect(c.Space.Name).To(Equal("otherSpace"))
+		})
+
+		It("returns an error when the space does not exist", func() {
+			orgs, err := mocks.FileToString("orgsV3.json")
+			Expect(err).ToNot(HaveOccurred())
+			empty, err := mocks.FileToString("emptyListV3.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations?names=orgB").Return(orgs, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces?names=missing&organization_guids=orgBGuid").Return(empty, nil)
+
+			Expect(c.TargetSpace("orgB", "missing")).To(MatchError("no space found with name missing in org orgB"))
+		})
+	})
+
+	When("TargetSpaceByGUID", func() {
+		It("looks up the space and its org", func() {
+			spaces, err := mocks.FileToString("spacesV3.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces?guids=otherSpaceGuid&include=organization").Return(spaces, nil)
+
+			Expect(c.TargetSpaceByGUID("otherSpaceGuid")).To(Succeed())
+			Expect(c.Org.Name).To(Equal("orgB"))
+			Expect(c.Space.Name).To(Equal("otherSpace"))
+		})
+	})
+
+	When("GetMemoryQuotas", func() {
+		It("returns the org quota when the space has none", func() {
+			mocks.ExpectDefaultResponses(mockConnection)
+
+			quotas, err := c.GetMemoryQuotas(mocks.SpaceGuid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quotas).To(HaveLen(1))
+			Expect(quotas[0].Kind).To(Equal("org"))
+			Expect(*quotas[0].LimitInMB).To(Equal(10240))
+			Expect(quotas[0].UsedInMB).To(Equal(2048))
+		})
+	})
 })
@@ -0,0 +1,348 @@
+package changer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	BulkSummaryMsg          = "%d of %d apps changed to Stack %s"
+	BulkFailureError        = "%d of %d apps could not be changed to stack %s"
+	AppFailedMsg            = "%s: %v"
+	ResumingMsg             = "Resuming migrations from journal %s...\n\n"
+	RevertingMsg            = "Reverting migrations from journal %s...\n\n"
+	AlreadyCompletedMsg     = "%s was already changed to Stack %s"
+	PreviouslyFailedMsg     = "%s previously failed and was rolled back: %s"
+	RollbackFailedMsg       = "%s previously failed and could not be rolled back, so it may still be on Stack %s; revert it with --revert: %s"
+	NothingToRevertMsg      = "%s was not changed and needs no revert"
+	RevertSuccessMsg        = "%s was reverted to Stack %s with droplet %s"
+	AlreadyRevertedMsg      = "%s was already reverted to Stack %s with droplet %s"
+	ResumeSummaryMsg        = "%d of %d apps in the journal are now on their target stack"
+	RevertSummaryMsg        = "%d of %d apps in the journal were reverted"
+	ExcludedSummaryMsg      = "%d apps were excluded from migration by annotation"
+	JournalFailureError     = "%d apps in the journal could not be processed"
+	ErrorJournalRequiresApp = "journal entry for %s has no app guid"
+)
+
+// ChangeStacks changes the stack of each app in the targeted space in turn,
+// continuing past failures, and returns a summary of the run
+func (c *Changer) ChangeStacks(appNames []string, newStack string) (string, error) {
+	var apps []bulkApp
+	for _, appName := range appNames {
+		c.record(c.newMigration(appName, newStack), StepPlanned, nil)
+		apps = append(apps, bulkApp{name: appName, org: c.CF.Org.Name, space: c.CF.Space.Name, change: func(w *Changer) (Result, error) {
+			return w.changeStack(appName, newStack, true)
+		}})
+	}
+
+	return c.runBulk(c.canaryWaves(apps), newStack, nil)
+}
+
+// bulkApp is an app in a bulk run and how to change its stack. target, when
+// set, points c at the app's space instead of the targeted one.
+type bulkApp struct {
+	name   string
+	org    string
+	space  string
+	target func(c *Changer) error
+	change func(c *Changer) (Result, error)
+}
+
+func (a bulkApp) spaceKey() string {
+	return a.org + "/" + a.space
+}
+
+// canaryWaves splits apps into the waves of the configured canary, or a
+// single wave without one
+func (c *Changer) canaryWaves(apps []bulkApp) [][]bulkApp {
+	if c.Canary == nil {
+		return [][]bulkApp{apps}
+	}
+
+	var waves [][]bulkApp
+	for _, size := range c.Canary.waveSizes(len(apps)) {
+		waves = append(waves, apps[:size])
+		apps = apps[size:]
+	}
+	return waves
+}
+
+// runBulk changes the apps of each wave in turn, continuing past failures.
+// It stops starting apps once the run is interrupted or a wave trips the
+// failure-rate gate. beforeWave, when given, prepares each wave. With
+// Estimate set it only projects the run.
+func (c *Changer) runBulk(waves [][]bulkApp, newStack string, beforeWave func(wave int)) (string, error) {
+	if c.Estimate {
+		return c.estimate(waves, newStack, beforeWave)
+	}
+
+	if c.Concurrency.parallel() {
+		c.CF.ShareConnection()
+	}
+
+	var names []string
+	for _, wave := range waves {
+		for _, app := range wave {
+			names = append(names, app.name)
+		}
+	}
+
+	var results []Result
+	started, notStarted, gated := 0, 0, 0
+	for i, wave := range waves {
+		switch {
+		case beforeWave != nil:
+			beforeWave(i)
+		case len(waves) > 1:
+			c.printf(CanaryWaveMsg, i+1, len(waves), len(wave))
+		}
+
+		started += len(wave)
+		waveResults, stopped := c.runWave(wave, names[started:])
+		results = append(results, waveResults...)
+		if notStarted = stopped; notStarted > 0 {
+			break
+		}
+
+		if !c.passGate(i+1, waveResults, len(names)-started) {
+			gated = c.stopAtGate(names[started:])
+			break
+		}
+	}
+
+	failures, excluded := 0, 0
+	for _, result := range results {
+		switch {
+		case result.FailureCategory == CategoryOptedOut:
+			excluded++
+		case !result.Success:
+			failures++
+		}
+	}
+
+	var err error
+	if failures > 0 {
+		err = fmt.Errorf(BulkFailureError, failures, len(names), newStack)
+	}
+	err = withGated(withNotStarted(err, notStarted), gated)
+	summary := fmt.Sprintf(BulkSummaryMsg, len(results)-failures-excluded, len(names), newStack)
+	return c.reportAll(results, nil, withExcluded(summary, excluded), err)
+}
+
+// reportAll formats the outcome of several stack changes for the configured
+// output type. Text output lists skipped apps and ends with the summary.
+func (c *Changer) reportAll(results []Result, skipped []string, summary string, err error) (string, error) {
+	if c.OutputType == JSONFlag {
+		return marshalResults(results, err)
+	}
+
+	lines := append([]string{}, skipped...)
+	for _, result := range results {
+		if result.Success {
+			lines = append(lines, result.successMsg())
+		} else {
+			lines = append(lines, fmt.Sprintf(AppFailedMsg, result.AppName, result.Error))
+		}
+	}
+	lines = append(lines, groupFailures(results)...)
+	lines = append(lines, summary)
+
+	return strings.Join(lines, "\n"), err
+}
+
+func marshalResults(results []Result, err error) (string, error) {
+	if results == nil {
+		results = []Result{}
+	}
+	out, marshalErr := json.Marshal(results)
+	if marshalErr != nil {
+		return "", marshalErr
+	}
+	return string(out), err
+}
+
+func withExcluded(summary string, excluded int) string {
+	if excluded == 0 {
+		return summary
+	}
+	return summary + "\n" + fmt.Sprintf(ExcludedSummaryMsg, excluded)
+}
+
+// ResumeJournal finishes every migration in the journal that was planned or
+// interrupted, or that failed without a rollback, starting each one after
+// the last step it completed. Failed migrations that were rolled back are
+// skipped, and those whose rollback failed are reported for a revert.
+func (c *Changer) ResumeJournal(path string) (string, error) {
+	c.printf(ResumingMsg, path)
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return "", err
+	}
+
+	var results []Result
+	var skipped []string
+	failures, completed, excluded, notStarted := 0, 0, 0, 0
+	for i, entry := range entries {
+		if notStarted = c.stopIfInterrupted(entryNames(entries[i:])); notStarted > 0 {
+			break
+		}
+
+		switch entry.Step {
+		case StepCompleted:
+			completed++
+			skipped = append(skipped, fmt.Sprintf(AlreadyCompletedMsg, entry.AppName, entry.NewStack))
+			continue
+		case StepFailed:
+			switch {
+			case entry.Rollback == RollbackFailed:
+				failures++
+				skipped = append(skipped, fmt.Sprintf(RollbackFailedMsg, entry.AppName, entry.NewStack, entry.Error))
+				continue
+			case entry.Rollback == RollbackSucceeded || entry.Reached == "":
+				skipped = append(skipped, fmt.Sprintf(PreviouslyFailedMsg, entry.AppName, entry.Error))
+				continue
+			}
+		case StepUnchanged:
+			completed++
+			skipped = append(skipped, fmt.Sprintf(AlreadyOnStackMsg, entry.AppName, entry.NewStack))
+			continue
+		case StepReverted:
+			skipped = append(skipped, fmt.Sprintf(RevertSuccessMsg, entry.AppName, entry.OldStack, entry.DropletGUID))
+			continue
+		}
+
+		c.awaitWindow()
+		if notStarted = c.stopIfInterrupted(entryNames(entries[i:])); notStarted > 0 {
+			break
+		}
+
+		result, err := c.resumeEntry(entry)
+		switch {
+		case FailureCategory(err) == CategoryOptedOut:
+			excluded++
+		case err != nil:
+			failures++
+		default:
+			completed++
+		}
+		results = append(results, result)
+	}
+
+	if failures > 0 {
+		err = fmt.Errorf(JournalFailureError, failures)
+	}
+	err = withNotStarted(err, notStarted)
+	summary := fmt.Sprintf(ResumeSummaryMsg, completed, len(entries))
+	return c.reportAll(results, skipped, withExcluded(summary, excluded), err)
+}
+
+func (c *Changer) resumeEntry(entry JournalEntry) (Result, error) {
+	if entry.Rollout != nil {
+		worker := *c
+		worker.useRollout(entry.Rollout)
+		c = &worker
+	}
+
+	if err := c.CF.TargetSpaceByGUID(entry.SpaceGUID); err != nil {
+		err = failure(CategoryAppLookup, err)
+		result := newResult(entry.Migration)
+		result.finish(err)
+		return result, err
+	}
+
+	if entry.lastDone() == StepPlanned {
+		return c.changeStack(entry.AppName, entry.NewStack, true)
+	}
+
+	if entry.AppGUID == "" {
+		err := failure(CategoryAppLookup, fmt.Errorf(ErrorJournalRequiresApp, entry.AppName))
+		result := newResult(entry.Migration)
+		result.finish(err)
+		return result, err
+	}
+
+	m := entry.Migration
+	c.printf(AttemptingToChangeStackMsg, m.NewStack, fmt.Sprintf("%s/%s/", m.Space, m.AppName))
+	return c.withHooks(&m, func() (Result, error) {
+		return c.change(&m, entry.lastDone())
+	})
+}
+
+// RevertJournal returns every app the journal shows as changed to its
+// original stack, droplet and state. JSON output has a result for each app,
+// rolled back if it was reverted and unchanged if it needed no revert.
+func (c *Changer) RevertJournal(path string) (string, error) {
+	c.printf(RevertingMsg, path)
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	var results []Result
+	failures, reverted, notStarted := 0, 0, 0
+	for i, entry := range entries {
+		if notStarted = c.stopIfInterrupted(entryNames(entries[i:])); notStarted > 0 {
+			break
+		}
+
+		switch {
+		case entry.Rollback == RollbackSucceeded:
+			lines = append(lines, fmt.Sprintf(PreviouslyFailedMsg, entry.AppName, entry.Error))
+			results = append(results, revertResult(entry, false, nil))
+			continue
+		case entry.Step == StepReverted:
+			lines = append(lines, fmt.Sprintf(AlreadyRevertedMsg, entry.AppName, entry.OldStack, entry.DropletGUID))
+			results = append(results, revertResult(entry, false, nil))
+			continue
+		case !entry.changed():
+			lines = append(lines, fmt.Sprintf(NothingToRevertMsg, entry.AppName))
+			results = append(results, revertResult(entry, false, nil))
+			continue
+		}
+
+		if err := c.revertEntry(entry); err != nil {
+			failures++
+			lines = append(lines, fmt.Sprintf(AppFailedMsg, entry.AppName, err))
+			results = append(results, revertResult(entry, false, err))
+			continue
+		}
+
+		reverted++
+		lines = append(lines, fmt.Sprintf(RevertSuccessMsg, entry.AppName, entry.OldStack, entry.DropletGUID))
+		results = append(results, revertResult(entry, true, nil))
+	}
+
+	if failures > 0 {
+		err = fmt.Errorf(JournalFailureError, failures)
+	}
+	err = withNotStarted(err, notStarted)
+	if c.OutputType == JSONFlag {
+		return marshalResults(results, err)
+	}
+
+	lines = append(lines, fmt.Sprintf(RevertSummaryMsg, reverted, len(entries)))
+	return strings.Join(lines, "\n"), err
+}
+
+func revertResult(entry JournalEntry, reverted bool, err error) Result {
+	result := newResult(entry.Migration)
+	result.Unchanged = !reverted && err == nil
+	if reverted {
+		result.RolledBack = true
+		result.NewDropletGUID = entry.DropletGUID
+		result.RestoredState = entry.State
+	}
+	result.finish(err)
+	return result
+}
+
+func (c *Changer) revertEntry(entry JournalEntry) error {
+	if err := c.CF.TargetSpaceByGUID(entry.SpaceGUID); err != nil {
+		return err
+	}
+
+	c.printf(AttemptingToRollbackStackMsg, fmt.Sprintf("%s/%s/", entry.Space, entry.AppName))
+	return c.revert(entry.Migration)
+}
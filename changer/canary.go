@@ -0,0 +1,130 @@
+package changer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	InvalidCanaryError      = "invalid canary size %q: expected a number of apps or a percentage such as 5%%"
+	InvalidFailureRateError = "invalid maximum failure rate %v: expected a percentage from 0 to 100"
+	CanaryWaveMsg           = "Canary wave %d of %d: %d apps\n\n"
+	GatePassedMsg           = "Wave %d: %d of %d apps failed (%.1f%%), within the %.1f%% limit\n\n"
+	GateTrippedMsg          = "Wave %d: %d of %d apps failed (%.1f%%), above the %.1f%% limit"
+	GateConfirmPrompt       = "Continue migrating the remaining %d apps?"
+	GateNotStartedMsg       = "%s: not started because the failure-rate gate stopped the run"
+	GateStoppedError        = "failure-rate gate stopped the run with %d apps not started"
+)
+
+// Canary splits a bulk run into waves that double in size, starting with a
+// number or percentage of the apps. After each wave the share of apps that
+// failed is compared with MaxFailureRate, and the run only continues past a
+// wave above it if the operator confirms.
+type Canary struct {
+	Size           int
+	Percent        float64
+	MaxFailureRate float64
+}
+
+// ParseCanary parses a first wave size such as "20" or "5%". An empty size
+// gates waves that are already defined, such as those of a plan.
+func ParseCanary(size string, maxFailureRate float64) (*Canary, error) {
+	if maxFailureRate < 0 || maxFailureRate > 100 {
+		return nil, fmt.Errorf(InvalidFailureRateError, maxFailureRate)
+	}
+
+	canary := &Canary{MaxFailureRate: maxFailureRate}
+	if size == "" {
+		return canary, nil
+	}
+
+	var err error
+	if percent, ok := strings.CutSuffix(size, "%"); ok {
+		canary.Percent, err = strconv.ParseFloat(percent, 64)
+		if err != nil || canary.Percent <= 0 || canary.Percent > 100 {
+			return nil, fmt.Errorf(InvalidCanaryError, size)
+		}
+		return canary, nil
+	}
+
+	canary.Size, err = strconv.Atoi(size)
+	if err != nil || canary.Size <= 0 {
+		return nil, fmt.Errorf(InvalidCanaryError, size)
+	}
+	return canary, nil
+}
+
+// waveSizes returns the size of each wave of a run of total apps: the first
+// wave, then each wave twice the size of the one before, with the last wave
+// taking whatever is left. Without a first wave size the run is one wave.
+func (c *Canary) waveSizes(total int) []int {
+	if c.Size == 0 && c.Percent == 0 {
+		return []int{total}
+	}
+
+	first := c.Size
+	if c.Percent > 0 {
+		first = max(1, int(float64(total)*c.Percent/100))
+	}
+
+	var sizes []int
+	for size := first; total > 0; size *= 2 {
+		size = min(size, total)
+		sizes = append(sizes, size)
+		total -= size
+	}
+	return sizes
+}
+
+// passGate reports whether the run may continue after a wave. A wave whose
+// failure rate is above the limit is reported with its failures grouped by
+// category, and the run continues only if the operator confirms.
+func (c *Changer) passGate(wave int, results []Result, remaining int) bool {
+	if c.Canary == nil || remaining == 0 {
+		return true
+	}
+
+	attempted, failures := 0, 0
+	for _, result := range results {
+		switch {
+		case result.FailureCategory == CategoryOptedOut:
+		case result.Success:
+			attempted++
+		default:
+			attempted++
+			failures++
+		}
+	}
+	if attempted == 0 {
+		return true
+	}
+
+	rate := float64(failures) * 100 / float64(attempted)
+	if rate <= c.Canary.MaxFailureRate {
+		c.printf(GatePassedMsg, wave, failures, attempted, rate, c.Canary.MaxFailureRate)
+		return true
+	}
+
+	lines := append([]string{fmt.Sprintf(GateTrippedMsg, wave, failures, attempted, rate, c.Canary.MaxFailureRate)}, groupFailures(results)...)
+	c.printf("%s\n\n", strings.Join(lines, "\n"))
+	return c.Confirm != nil && c.Confirm(fmt.Sprintf(GateConfirmPrompt, remaining))
+}
+
+// stopAtGate lists the apps a tripped gate kept from starting
+func (c *Changer) stopAtGate(remaining []string) int {
+	for _, appName := range remaining {
+		c.printf(GateNotStartedMsg+"\n", appName)
+	}
+	return len(remaining)
+}
+
+func withGated(err error, gated int) error {
+	if gated == 0 {
+		return err
+	}
+	if err == nil {
+		return failure(CategoryGated, fmt.Errorf(GateStoppedError, gated))
+	}
+	return failure(CategoryGated, fmt.Errorf("%w; "+GateStoppedError, err, gated))
+}
@@ -0,0 +1,141 @@
+package changer_test
+
+import (
+	"fmt"
+	"io"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/planner"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Canary", func() {
+	var prompts []string
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockRunner = NewMockRunner(mockCtrl)
+		prompts = nil
+
+		appA, err := mocks.FileToString("appAOptOutExpired.json")
+		Expect(err).ToNot(HaveOccurred())
+		errorV3, err := mocks.FileToString("errorV3.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid).Return(appA, nil).AnyTimes()
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/droplets/current").Return(errorV3, nil).AnyTimes()
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Log: func(w io.Writer, msg string) {
+				logMsg = msg
+			},
+			Canary: &changer.Canary{Size: 1},
+			LogDir: GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	confirm := func(answer bool) func(string) bool {
+		return func(prompt string) bool {
+			prompts = append(prompts, prompt)
+			return answer
+		}
+	}
+
+	expectAppAChanged := func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+	}
+
+	It("continues past a canary wave within the failure rate", func() {
+		c.Confirm = confirm(false)
+		expectAppAChanged()
+
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		Expect(prompts).To(BeEmpty())
+	})
+
+	It("stops when a wave fails too often and the operator does not confirm", func() {
+		c.Confirm = confirm(false)
+
+		result, err := c.ChangeStacks([]string{AppBName, AppAName}, StackBName)
+		Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryGated))
+		Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.GateStoppedError, 1)))
+		Expect(prompts).To(Equal([]string{fmt.Sprintf(changer.GateConfirmPrompt, 1)}))
+		Expect(logMsg).To(ContainSubstring(fmt.Sprintf(changer.GateNotStartedMsg, AppAName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 0, 2, StackBName)))
+	})
+
+	It("continues when a wave fails too often and the operator confirms", func() {
+		c.Confirm = confirm(true)
+		expectAppAChanged()
+
+		result, err := c.ChangeStacks([]string{AppBName, AppAName}, StackBName)
+		Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		Expect(prompts).To(HaveLen(1))
+	})
+
+	It("gates the waves of a plan", func() {
+		c.Canary = &changer.Canary{MaxFailureRate: 50}
+		c.Confirm = confirm(false)
+
+		_, err := c.ApplyPlan(planner.Plan{
+			From: StackAName,
+			To:   StackBName,
+			Waves: []planner.Wave{
+				{Name: "first", Strategy: planner.StrategyRolling, Apps: []planner.App{{Org: "commonOrg", Space: mocks.SpaceName, Name: AppBName}}},
+				{Name: "second", Strategy: planner.StrategyRolling, Apps: []planner.App{{Org: "commonOrg", Space: mocks.SpaceName, Name: AppAName}}},
+			},
+		})
+		Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryGated))
+		Expect(prompts).To(HaveLen(1))
+	})
+
+	DescribeTable("parses the first wave size",
+		func(size string, expected changer.Canary) {
+			canary, err := changer.ParseCanary(size, 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*canary).To(Equal(expected))
+		},
+		Entry("as a number of apps", "20", changer.Canary{Size: 20, MaxFailureRate: 5}),
+		Entry("as a percentage", "2.5%", changer.Canary{Percent: 2.5, MaxFailureRate: 5}),
+		Entry("as nothing, to gate existing waves", "", changer.Canary{MaxFailureRate: 5}),
+	)
+
+	DescribeTable("rejects invalid canaries",
+		func(size string, rate float64, expected string) {
+			_, err := changer.ParseCanary(size, rate)
+			Expect(err).To(MatchError(expected))
+		},
+		Entry("a zero size", "0", 0.0, fmt.Sprintf(changer.InvalidCanaryError, "0")),
+		Entry("a percentage above 100", "150%", 0.0, fmt.Sprintf(changer.InvalidCanaryError, "150%")),
+		Entry("a word", "some", 0.0, fmt.Sprintf(changer.InvalidCanaryError, "some")),
+		Entry("a negative failure rate", "5", -1.0, fmt.Sprintf(changer.InvalidFailureRateError, -1.0)),
+	)
+})
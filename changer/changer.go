@@ -1,100 +1,475 @@
 package changer
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/cloudfoundry/stack-auditor/cf"
 )
 
 const (
-	AttemptingToChangeStackMsg = "Attempting to change stack to %s for %s...\n\n"
-	ChangeStackSuccessMsg      = "Application %s was successfully changed to Stack %s"
-	AppStackAssociationError   = "application is already associated with stack %s"
-	RestoringStateMsg          = "Restoring prior application state: %s"
-	ErrorChangingStack         = "problem assigning target stack to %s"
-	ErrorRestagingApp          = "problem restaging app on %s"
-	ErrorRestoringState        = "problem restoring application state to %s"
+	AttemptingToChangeStackMsg   = "Attempting to change stack to %s for %s...\n\n"
+	AttemptingToRollbackStackMsg = "Attempting to roll back stack for %s...\n\n"
+	ChangeStackSuccessMsg        = "Application %s was successfully changed to Stack %s"
+	RollbackStackSuccessMsg      = "Application %s was successfully rolled back to Stack %s with droplet %s"
+	AlreadyOnStackMsg            = "Application %s is already on Stack %s"
+	FinishingMigrationMsg        = "Application %s is assigned its new stack but runs a droplet built on %s; finishing the restage"
+	FinishingUnstagedMsg         = "Application %s is assigned its new stack but was never staged; finishing the restage"
+	RestoringStateMsg            = "Restoring prior application state: %s"
+	RestoringDropletMsg          = "Restoring prior droplet: %s"
+	ErrorChangingStack           = "problem assigning target stack to %s"
+	ErrorRestagingApp            = "problem restaging app on %s"
+	ErrorRestoringState          = "problem restoring application state to %s"
+	ErrorRestoringDroplet        = "problem restoring droplet %s"
+	ErrorGettingDroplet          = "problem getting current droplet for %s"
+	NoPreviousDropletError       = "no staged droplet found for %s on a stack other than %s"
+	NoCurrentDropletMsg          = "Application %s has no current droplet; a failed change cannot restore one"
+	DockerAppError               = "application uses a docker image and has no stack to change"
+	UnsupportedLifecycleError    = "application lifecycle type %s is not supported"
 )
 
 type RequestData struct {
 	LifeCycle struct {
+		Type string `json:"type"`
 		Data struct {
-			Stack string `json:"stack"`
+			Buildpacks []string `json:"buildpacks,omitempty"`
+			Stack      string   `json:"stack"`
 		} `json:"data"`
 	} `json:"lifecycle"`
 }
 
+type relationshipData struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+type deploymentRequest struct {
+	Droplet struct {
+		GUID string `json:"guid"`
+	} `json:"droplet"`
+	Strategy      string `json:"strategy"`
+	Relationships struct {
+		App relationshipData `json:"app"`
+	} `json:"relationships"`
+}
+
 type Changer struct {
-	CF     cf.CF
-	Runner Runner
-	Log    func(writer io.Writer, msg string)
+	CF           cf.CF
+	Runner       Runner
+	Log          func(writer io.Writer, msg string)
+	Verification Verification
+	Journal      *Journal
+	OutputType   string
+	Interrupts   *Interrupts
+	LogDir       string
+	Window       *Window
+	Hooks        Hooks
+	Version      string
+	Strategy     string
+	Canary       *Canary
+	Confirm      func(prompt string) bool
+	Concurrency  Concurrency
+	Policy       *Policy
+	InFlight     InFlight
+	Estimate     bool
+	Rollout      *Rollout
+
+	// from is the stack the app being changed was planned or listed on
+	from string
 }
 
 type Runner interface {
 	Run(bin, dir string, quiet bool, args ...string) error
+	RunWithEnv(bin, dir string, quiet bool, env []string, args ...string) error
 	RunWithOutput(bin, dir string, quiet bool, args ...string) (string, error)
+	RunWithOutputEnv(bin, dir string, quiet bool, env []string, args ...string) (string, error)
 	SetEnv(variableName string, path string) error
 }
 
 func (c *Changer) ChangeStack(appName, newStack string) (string, error) {
-	fmt.Printf(AttemptingToChangeStackMsg, newStack, fmt.Sprintf("%s/%s/", c.CF.Space.Name, appName))
-	appGuid, appState, oldStack, err := c.CF.GetAppInfo(appName)
+	c.awaitWindow()
+	if notStarted := c.stopIfInterrupted([]string{appName}); notStarted > 0 {
+		return "", withNotStarted(nil, notStarted)
+	}
+
+	return c.report(c.changeStack(appName, newStack, false))
+}
+
+// changeStack changes the stack of the named app in the targeted space. Bulk
+// runs honor the opt-out annotations of the app and its space. Changing an
+// app whose stack is already newStack finishes a migration that stopped
+// before the restage, or succeeds without changes if its droplet was also
+// built on newStack, so that a run can safely be repeated.
+func (c *Changer) changeStack(appName, newStack string, bulk bool) (Result, error) {
+	c.printf(AttemptingToChangeStackMsg, newStack, fmt.Sprintf("%s/%s/", c.CF.Space.Name, appName))
+	m := c.newMigration(appName, newStack)
+	result := newResult(m)
+
+	app, err := c.CF.GetAppByName(appName)
 	if err != nil {
-		return "", err
+		err = failure(CategoryAppLookup, err)
+		result.finish(err)
+		return result, err
 	}
 
-	if oldStack == newStack {
-		return "", fmt.Errorf(AppStackAssociationError, newStack)
+	m.AppGUID = app.GUID
+	m.OldStack = app.Lifecycle.Data.Stack
+	m.State = app.State
+	result.update(m)
+
+	if bulk {
+		if err := c.checkOptOut(app); err != nil {
+			result.finish(err)
+			return result, err
+		}
 	}
 
-	if err := c.change(appName, appGuid, oldStack, newStack, appState); err != nil {
-		return "", err
+	if err := checkLifecycle(app.Lifecycle.Type); err != nil {
+		err = failure(CategoryUnsupportedApp, err)
+		result.finish(err)
+		return result, err
+	}
+
+	if app.Lifecycle.Data.Stack == newStack {
+		droplet, err := c.CF.GetCurrentDroplet(app.GUID)
+		switch {
+		case errors.Is(err, cf.ErrNoCurrentDroplet):
+			c.printf(FinishingUnstagedMsg+"\n", appName)
+			m.stackAssigned = true
+		case err != nil:
+			err = failure(CategoryRecording, fmt.Errorf(ErrorGettingDroplet+": %w", appName, err))
+			result.finish(err)
+			return result, err
+		case droplet.Stack == newStack:
+			m.DropletGUID = droplet.GUID
+			c.record(m, StepUnchanged, nil)
+			result.update(m)
+			result.NewDropletGUID = droplet.GUID
+			result.Unchanged = true
+			result.finish(nil)
+			return result, nil
+		default:
+			c.printf(FinishingMigrationMsg+"\n", appName, droplet.Stack)
+			m.OldStack = droplet.Stack
+			m.stackAssigned = true
+			result.update(m)
+		}
+	}
+
+	if err := c.checkListed(m.OldStack); err != nil {
+		result.finish(err)
+		return result, err
+	}
+
+	if err := c.checkPolicy(m.OldStack, newStack); err != nil {
+		result.finish(err)
+		return result, err
+	}
+
+	if err := c.awaitIdle(app); err != nil {
+		result.finish(err)
+		return result, err
 	}
 
-	return fmt.Sprintf(ChangeStackSuccessMsg, appName, newStack), nil
+	if err := c.preflight(app, newStack); err != nil {
+		result.finish(err)
+		return result, err
+	}
+
+	return c.withHooks(&m, func() (Result, error) {
+		return c.change(&m, StepPlanned)
+	})
 }
 
-func (c *Changer) change(appName, appGUID, oldStack, newStack, appInitialState string) error {
-	err := c.assignTargetStack(appGUID, newStack)
+// RollbackStack returns an app to its most recent staged droplet built on a
+// stack other than the one the app is currently associated with
+func (c *Changer) RollbackStack(appName string) (string, error) {
+	c.printf(AttemptingToRollbackStackMsg, fmt.Sprintf("%s/%s/", c.CF.Space.Name, appName))
+	appGuid, appState, currentStack, err := c.CF.GetAppInfo(appName)
 	if err != nil {
-		return fmt.Errorf(ErrorChangingStack+": %w", newStack, err)
+		return "", err
 	}
 
-	err = c.Runner.Run("cf", ".", true, "restage", "--strategy", "rolling", appName)
-
+	droplets, err := c.CF.GetStagedDroplets(appGuid)
 	if err != nil {
-		err = fmt.Errorf(ErrorRestagingApp+": %w", newStack, err)
-		if restartErr := c.assignTargetStack(appGUID, oldStack); restartErr != nil {
-			err = fmt.Errorf(ErrorChangingStack+": %w", oldStack, err)
+		return "", err
+	}
+
+	for _, droplet := range droplets {
+		if droplet.Stack == currentStack {
+			continue
 		}
-		if restoreErr := c.restoreAppState(appGUID, appInitialState); restoreErr != nil {
-			err = fmt.Errorf(ErrorRestoringState+": %w", appInitialState, err)
+
+		if err := c.rollback(appGuid, droplet.Stack, droplet.GUID, appState); err != nil {
+			return "", err
 		}
-		return err
+		c.unlabelMigrated(appGuid, appName)
+		return fmt.Sprintf(RollbackStackSuccessMsg, appName, droplet.Stack, droplet.GUID), nil
+	}
+
+	return "", fmt.Errorf(NoPreviousDropletError, appName, currentStack)
+}
+
+func (c *Changer) newMigration(appName, newStack string) Migration {
+	return Migration{
+		AppName:   appName,
+		Org:       c.CF.Org.Name,
+		Space:     c.CF.Space.Name,
+		SpaceGUID: c.CF.Space.Guid,
+		NewStack:  newStack,
+		Rollout:   c.Rollout,
+	}
+}
+
+// change performs every step of the migration after done, the last step
+// already completed for it
+func (c *Changer) change(m *Migration, done string) (Result, error) {
+	result := newResult(*m)
+	m.reached = done
+	err := c.changeSteps(m, done, &result)
+	result.update(*m)
+	if err != nil && FailureCategory(err) != CategoryInterrupted {
+		c.recordFailure(*m, err)
+	}
+	result.finish(err)
+	return result, err
+}
+
+func (c *Changer) changeSteps(m *Migration, done string, r *Result) error {
+	if !stepDone(done, StepRecorded) {
+		start := time.Now()
+		droplet, err := c.CF.GetCurrentDroplet(m.AppGUID)
+		switch {
+		case errors.Is(err, cf.ErrNoCurrentDroplet):
+			c.printf(NoCurrentDropletMsg+"\n", m.AppName)
+		case err != nil:
+			return failure(CategoryRecording, fmt.Errorf(ErrorGettingDroplet+": %w", m.AppName, err))
+		}
+		m.DropletGUID = droplet.GUID
+
+		m.Snapshot, err = c.snapshot(m.AppGUID)
+		if err != nil {
+			return failure(CategoryRecording, fmt.Errorf(ErrorSnapshottingApp+": %w", m.AppName, err))
+		}
+		c.advance(m, StepRecorded)
+		r.timed(StepRecorded, start)
+	}
+
+	if !stepDone(done, StepStackAssigned) {
+		if err := c.interrupted(StepStackAssigned); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		if !m.stackAssigned {
+			if err := c.assignTargetStack(m.AppGUID, m.NewStack); err != nil {
+				return classify(CategoryStackAssignment, fmt.Errorf(ErrorChangingStack+": %w", m.NewStack, err))
+			}
+		}
+		c.advance(m, StepStackAssigned)
+		r.timed(StepStackAssigned, start)
+	}
+
+	if !stepDone(done, StepRestaged) {
+		if err := c.interrupted(StepRestaged); err != nil {
+			return c.revertStack(m, r, err)
+		}
+
+		start := time.Now()
+		if err := c.restage(m); err != nil {
+			r.timed(StepRestaged, start)
+			return c.rollbackAfter(m, r, classify(CategoryRestage, fmt.Errorf(ErrorRestagingApp+": %w", m.NewStack, err)))
+		}
+		c.advance(m, StepRestaged)
+		r.timed(StepRestaged, start)
+	}
+
+	droplet, err := c.CF.GetCurrentDroplet(m.AppGUID)
+	if err == nil {
+		r.NewDropletGUID = droplet.GUID
+	}
+
+	if !stepDone(done, StepProcessesRestored) {
+		start := time.Now()
+		drift, err := c.reportDrift(m)
+		r.Drift = append(r.Drift, drift...)
+		if err != nil {
+			return failure(CategoryProcessRestore, err)
+		}
+		c.advance(m, StepProcessesRestored)
+		r.timed(StepProcessesRestored, start)
 	}
 
-	return c.restoreAppState(appGUID, appInitialState)
+	if !stepDone(done, StepStateRestored) {
+		start := time.Now()
+		if err := c.restoreAppState(m.AppGUID, m.State); err != nil {
+			return classify(CategoryStateRestore, err)
+		}
+		c.advance(m, StepStateRestored)
+		r.timed(StepStateRestored, start)
+	}
+	r.RestoredState = m.State
+
+	if m.State == "STARTED" && c.Verification.Enabled() {
+		start := time.Now()
+		if err := c.verify(m.AppGUID); err != nil {
+			r.timed(PhaseVerification, start)
+			return c.rollbackAfter(m, r, failure(CategoryVerification, fmt.Errorf(ErrorVerifyingApp+": %w", m.NewStack, err)))
+		}
+		r.timed(PhaseVerification, start)
+	}
+
+	c.labelMigrated(m)
+	c.advance(m, StepCompleted)
+	return nil
+}
+
+// revertStack returns the app to its original stack when err stopped the
+// migration before the app was restaged. The droplet is untouched, so the
+// journal is left at the recorded step for a later resume.
+func (c *Changer) revertStack(m *Migration, r *Result, err error) error {
+	if revertErr := c.assignTargetStack(m.AppGUID, m.OldStack); revertErr != nil {
+		return fmt.Errorf("%w; "+ErrorChangingStack+": %v", err, m.OldStack, revertErr)
+	}
+	c.advance(m, StepRecorded)
+
+	r.RolledBack = true
+	r.NewDropletGUID = m.DropletGUID
+	r.RestoredState = m.State
+	return err
+}
+
+// rollbackAfter returns the app to its original stack, droplet and state
+// after err interrupted its migration
+func (c *Changer) rollbackAfter(m *Migration, r *Result, err error) error {
+	start := time.Now()
+	defer r.timed(PhaseRollback, start)
+
+	m.rollback = RollbackFailed
+	if rollbackErr := c.rollback(m.AppGUID, m.OldStack, m.DropletGUID, m.State); rollbackErr != nil {
+		return fmt.Errorf("%w; %v", err, rollbackErr)
+	}
+
+	drift, driftErr := c.reportDrift(m)
+	r.Drift = append(r.Drift, drift...)
+	if driftErr != nil {
+		return fmt.Errorf("%w; %v", err, driftErr)
+	}
+
+	m.rollback = RollbackSucceeded
+	r.RolledBack = true
+	r.NewDropletGUID = m.DropletGUID
+	r.RestoredState = m.State
+	return err
 }
 
+// rollback associates the app with the given stack, makes the given droplet
+// current again and restarts the app on it if the app was running. An app
+// that had no droplet only gets its stack and state back.
+func (c *Changer) rollback(appGUID, stack, dropletGUID, appInitialState string) error {
+	if err := c.assignTargetStack(appGUID, stack); err != nil {
+		return fmt.Errorf(ErrorChangingStack+": %w", stack, err)
+	}
+
+	if dropletGUID != "" {
+		if err := c.restoreDroplet(appGUID, dropletGUID, appInitialState); err != nil {
+			return fmt.Errorf(ErrorRestoringDroplet+": %w", dropletGUID, err)
+		}
+	}
+
+	if err := c.restoreAppState(appGUID, appInitialState); err != nil {
+		return fmt.Errorf(ErrorRestoringState+": %w", appInitialState, err)
+	}
+
+	return nil
+}
+
+// assignTargetStack changes the stack of the app's current lifecycle, keeping
+// its type and buildpacks
 func (c *Changer) assignTargetStack(appGuid, stackName string) error {
-	_, err := c.CF.CFCurl("/v3/apps/"+appGuid, "-X", "PATCH", `-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+stackName+`"} } }`)
+	app, err := c.CF.GetAppByGUID(appGuid)
+	if err != nil {
+		return err
+	}
+
+	if err := checkLifecycle(app.Lifecycle.Type); err != nil {
+		return err
+	}
+
+	var request RequestData
+	request.LifeCycle.Type = app.Lifecycle.Type
+	request.LifeCycle.Data.Buildpacks = app.Lifecycle.Data.Buildpacks
+	request.LifeCycle.Data.Stack = stackName
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CF.Mutate(cf.ActionAssignStack, appGuid, "/v3/apps/"+appGuid, "-X", "PATCH", "-d="+string(body))
+	return err
+}
+
+func checkLifecycle(lifecycleType string) error {
+	switch lifecycleType {
+	case "buildpack", "cnb":
+		return nil
+	case "docker":
+		return errors.New(DockerAppError)
+	default:
+		return fmt.Errorf(UnsupportedLifecycleError, lifecycleType)
+	}
+}
+
+// restoreDroplet sets the current droplet and, for a started app, rolls its
+// instances onto it so the restart causes no downtime
+func (c *Changer) restoreDroplet(appGuid, dropletGuid, appInitialState string) error {
+	c.printf(RestoringDropletMsg+"\n", dropletGuid)
+
+	var current relationshipData
+	current.Data.GUID = dropletGuid
+	body, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.CF.Mutate(cf.ActionSetDroplet, appGuid, "/v3/apps/"+appGuid+"/relationships/current_droplet", "-X", "PATCH", "-d="+string(body)); err != nil {
+		return err
+	}
+
+	if appInitialState != "STARTED" {
+		return nil
+	}
+
+	var deployment deploymentRequest
+	deployment.Droplet.GUID = dropletGuid
+	deployment.Strategy = "rolling"
+	deployment.Relationships.App.Data.GUID = appGuid
+	body, err = json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CF.Mutate(cf.ActionCreateDeployment, appGuid, "/v3/deployments", "-X", "POST", "-d="+string(body))
 	return err
 }
 
 func (c *Changer) restoreAppState(appGuid, appInitialState string) error {
-	var action string
+	var action, auditAction string
 
 	switch appInitialState {
 	case "STARTED":
-		action = "start"
+		action, auditAction = "start", cf.ActionStartApp
 	case "STOPPED":
-		action = "stop"
+		action, auditAction = "stop", cf.ActionStopApp
 	default:
 		return fmt.Errorf("unhandled initial application state (%s)", appInitialState)
 	}
 
-	fmt.Println(fmt.Sprintf(RestoringStateMsg, appInitialState))
-	_, err := c.CF.CFCurl("/v3/apps/"+appGuid+"/actions/"+action, "-X", "POST")
+	c.printf(RestoringStateMsg+"\n", appInitialState)
+	_, err := c.CF.Mutate(auditAction, appGuid, "/v3/apps/"+appGuid+"/actions/"+action, "-X", "POST")
 	return err
 }
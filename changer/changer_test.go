@@ -1,9 +1,16 @@
 package changer_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	plugin_models "code.cloudfoundry.org/cli/plugin/models"
 
@@ -11,6 +18,7 @@ import (
 
 	"github.com/cloudfoundry/stack-auditor/changer"
 	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/resources"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
@@ -18,12 +26,16 @@ import (
 )
 
 const (
-	AppAName   = "appA"
-	AppAGuid   = "appAGuid"
-	AppBName   = "appB"
-	AppBGuid   = "appBGuid"
-	StackAName = "stackA"
-	StackBName = "stackB"
+	AppAName                = "appA"
+	AppAGuid                = "appAGuid"
+	AppBName                = "appB"
+	AppBGuid                = "appBGuid"
+	AppADropletGuid         = "appADropletGuid"
+	AppBDropletGuid         = "appBDropletGuid"
+	AppAPreviousDropletGuid = "appAPreviousDropletGuid"
+	AppAWebProcessGuid      = "appAWebProcessGuid"
+	StackAName              = "stackA"
+	StackBName              = "stackB"
 )
 
 //go:generate mockgen -source=changer.go -destination=mocks_test.go -package=changer_test
@@ -47,7 +59,7 @@ var _ = Describe("Changer", func() {
 			CF: cf.CF{
 				Conn: mockConnection,
 				Space: plugin_models.Space{
-					plugin_models.SpaceFields{
+					SpaceFields: plugin_models.SpaceFields{
 						Guid: mocks.SpaceGuid,
 						Name: mocks.SpaceName,
 					},
@@ -56,6 +68,7 @@ var _ = Describe("Changer", func() {
 			Log: func(w io.Writer, msg string) {
 				logMsg = msg
 			},
+			LogDir: GinkgoT().TempDir(),
 		}
 
 	})
@@ -71,7 +84,7 @@ var _ = Describe("Changer", func() {
 				"/v3/apps/"+AppAGuid,
 				"-X",
 				"PATCH",
-				`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackBName+`"} } }`,
+				lifecycleRequest(StackBName),
 			).Return([]string{}, nil)
 
 			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
@@ -81,13 +94,50 @@ var _ = Describe("Changer", func() {
 				"POST",
 			)
 
-			mockRunner.EXPECT().Run("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
 
 			result, err := c.ChangeStack(AppAName, StackBName)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(result).To(Equal(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
 		})
 
+		It("records every change it makes in the audit log", func() {
+			auditPath := filepath.Join(GinkgoT().TempDir(), "audit.log")
+			var err error
+			c.CF.AuditLog, err = cf.OpenAuditLog(auditPath, "some-user", "https://api.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			defer c.CF.AuditLog.Close()
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return(nil, errors.New("start failed"))
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+			_, err = c.ChangeStack(AppAName, StackBName)
+			Expect(err).To(HaveOccurred())
+
+			contents, err := os.ReadFile(auditPath)
+			Expect(err).NotTo(HaveOccurred())
+			var entries []cf.AuditEntry
+			for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+				var entry cf.AuditEntry
+				Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+				entries = append(entries, entry)
+			}
+
+			Expect(entries).To(HaveLen(3))
+			Expect(entries[0].User).To(Equal("some-user"))
+			Expect(entries[0].API).To(Equal("https://api.example.com"))
+			Expect(entries[0].Endpoint).To(Equal("PATCH /v3/apps/" + AppAGuid))
+			Expect(entries[0].TargetGUID).To(Equal(AppAGuid))
+			Expect(entries[0].Action).To(Equal(cf.ActionAssignStack))
+			Expect(entries[0].Result).To(Equal(cf.AuditSuccess))
+			Expect(entries[1].Endpoint).To(Equal("cf restage --strategy rolling " + AppAName))
+			Expect(entries[1].Action).To(Equal(cf.ActionRestage))
+			Expect(entries[2].Action).To(Equal(cf.ActionStartApp))
+			Expect(entries[2].Result).To(Equal(cf.AuditFailure))
+			Expect(entries[2].Error).To(Equal("start failed"))
+		})
+
 		When("there is an error changing stack metadata", func() {
 			It("returns a useful error message", func() {
 				errorMsg, err := mocks.FileToString("lifecycleV3Error.json")
@@ -97,7 +147,7 @@ var _ = Describe("Changer", func() {
 					"/v3/apps/"+AppAGuid,
 					"-X",
 					"PATCH",
-					`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackBName+`"} } }`,
+					lifecycleRequest(StackBName),
 				).Return(errorMsg, nil)
 
 				_, err = c.ChangeStack(AppAName, StackBName)
@@ -113,18 +163,35 @@ var _ = Describe("Changer", func() {
 					"/v3/apps/"+AppAGuid,
 					"-X",
 					"PATCH",
-					`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackBName+`"} } }`,
+					lifecycleRequest(StackBName),
 				).Return([]string{}, nil)
 
 				restageError := errors.New("restage failed")
-				mockRunner.EXPECT().Run("cf", ".", true, "restage", "--strategy", "rolling", AppAName).Return(restageError)
+				stagingOutput := "Staging app and tracing logs...\nNone of the buildpacks detected a compatible application"
+				mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).Return(stagingOutput, restageError)
 
 				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
 					"curl",
 					"/v3/apps/"+AppAGuid,
 					"-X",
 					"PATCH",
-					`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackAName+`"} } }`,
+					lifecycleRequest(StackAName),
+				).Return([]string{}, nil)
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/apps/"+AppAGuid+"/relationships/current_droplet",
+					"-X",
+					"PATCH",
+					`-d={"data":{"guid":"`+AppADropletGuid+`"}}`,
+				).Return([]string{}, nil)
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/deployments",
+					"-X",
+					"POST",
+					`-d={"droplet":{"guid":"`+AppADropletGuid+`"},"strategy":"rolling","relationships":{"app":{"data":{"guid":"`+AppAGuid+`"}}}}`,
 				).Return([]string{}, nil)
 
 				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
@@ -137,6 +204,14 @@ var _ = Describe("Changer", func() {
 				_, err := c.ChangeStack(AppAName, StackBName)
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(ContainSubstring(changer.ErrorRestagingApp, StackBName))
+				Expect(err.Error()).To(ContainSubstring("None of the buildpacks detected a compatible application"))
+				Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryNoBuildpackDetected))
+				Expect(err.Error()).To(ContainSubstring("Hint: set the app's buildpack explicitly"))
+
+				var stagingErr *changer.StagingError
+				Expect(errors.As(err, &stagingErr)).To(BeTrue())
+				Expect(filepath.Dir(stagingErr.LogPath)).To(Equal(c.LogDir))
+				Expect(os.ReadFile(stagingErr.LogPath)).To(ContainSubstring("Staging app and tracing logs..."))
 			})
 
 			When("the app is stopped", func() {
@@ -146,18 +221,26 @@ var _ = Describe("Changer", func() {
 						"/v3/apps/"+AppBGuid,
 						"-X",
 						"PATCH",
-						`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackAName+`"} } }`,
+						lifecycleRequest(StackAName),
 					).Return([]string{}, nil)
 
 					restageError := errors.New("restage failed")
-					mockRunner.EXPECT().Run("cf", ".", true, "restage", "--strategy", "rolling", AppBName).Return(restageError)
+					mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppBName).Return("", restageError)
 
 					mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
 						"curl",
 						"/v3/apps/"+AppBGuid,
 						"-X",
 						"PATCH",
-						`-d={"lifecycle":{"type":"buildpack", "data": {"stack":"`+StackBName+`"} } }`,
+						lifecycleRequest(StackBName),
+					).Return([]string{}, nil)
+
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+						"curl",
+						"/v3/apps/"+AppBGuid+"/relationships/current_droplet",
+						"-X",
+						"PATCH",
+						`-d={"data":{"guid":"`+AppBDropletGuid+`"}}`,
 					).Return([]string{}, nil)
 
 					mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
@@ -172,11 +255,581 @@ var _ = Describe("Changer", func() {
 					Expect(err.Error()).To(ContainSubstring(changer.ErrorRestagingApp, StackAName))
 				})
 			})
+
+			When("the app has no current droplet", func() {
+				It("restores the stack and state without restoring a droplet", func() {
+					dropletNotFound, err := mocks.FileToString("dropletNotFound.json")
+					Expect(err).ToNot(HaveOccurred())
+
+					mockConnection = mocks.NewMockCliConnection(mockCtrl)
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/droplets/current").Return(dropletNotFound, nil).AnyTimes()
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid, "-X", "PATCH", lifecycleRequest(StackAName)).Return([]string{}, nil)
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/actions/stop", "-X", "POST").Return([]string{}, nil)
+					mocks.ExpectDefaultResponses(mockConnection)
+					c.CF.Conn = mockConnection
+					var logged []string
+					c.Log = func(w io.Writer, msg string) {
+						logged = append(logged, msg)
+					}
+
+					mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppBName).Return("", errors.New("restage failed"))
+
+					_, err = c.ChangeStack(AppBName, StackAName)
+					Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryRestage))
+					Expect(logged).To(ContainElement(fmt.Sprintf(changer.NoCurrentDropletMsg+"\n", AppBName)))
+				})
+			})
+		})
+
+		It("refuses a probe URL without a verification window", func() {
+			v := changer.Verification{ProbeURL: "https://example.com/health"}
+			Expect(v.Validate()).To(MatchError(changer.ProbeWithoutVerifyError))
+
+			v.Duration = time.Minute
+			Expect(v.Validate()).To(Succeed())
+		})
+
+		When("verification is enabled", func() {
+			BeforeEach(func() {
+				c.Verification = changer.Verification{
+					Duration: 20 * time.Millisecond,
+					Interval: 5 * time.Millisecond,
+				}
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/apps/"+AppAGuid,
+					"-X",
+					"PATCH",
+					lifecycleRequest(StackBName),
+				).Return([]string{}, nil)
+
+				mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/apps/"+AppAGuid+"/actions/start",
+					"-X",
+					"POST",
+				).Return([]string{}, nil)
+			})
+
+			It("succeeds when all instances stay running", func() {
+				stats, err := mocks.FileToString("processStatsRunning.json")
+				Expect(err).ToNot(HaveOccurred())
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/processes/"+AppAWebProcessGuid+"/stats",
+				).Return(stats, nil).MinTimes(2)
+
+				result, err := c.ChangeStack(AppAName, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+			})
+
+			When("an instance crashes", func() {
+				It("rolls back to the previous droplet", func() {
+					stats, err := mocks.FileToString("processStatsCrashed.json")
+					Expect(err).ToNot(HaveOccurred())
+
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+						"curl",
+						"/v3/processes/"+AppAWebProcessGuid+"/stats",
+					).Return(stats, nil)
+
+					expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+					_, err = c.ChangeStack(AppAName, StackBName)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring(changer.ErrorVerifyingApp, StackBName))
+					Expect(err.Error()).To(ContainSubstring(changer.InstanceCrashedError, 0, "web", "CRASHED"))
+				})
+			})
+
+			When("the probe returns an unexpected status", func() {
+				It("rolls back to the previous droplet", func() {
+					server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusBadGateway)
+					}))
+					defer server.Close()
+					c.Verification.ProbeURL = server.URL
+
+					stats, err := mocks.FileToString("processStatsRunning.json")
+					Expect(err).ToNot(HaveOccurred())
+
+					mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+						"curl",
+						"/v3/processes/"+AppAWebProcessGuid+"/stats",
+					).Return(stats, nil)
+
+					expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+					_, err = c.ChangeStack(AppAName, StackBName)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring(changer.ProbeStatusError, server.URL, http.StatusBadGateway, http.StatusOK))
+				})
+			})
+		})
+
+		When("the app is outside the targeted space", func() {
+			It("restages the app without changing the CLI target", func() {
+				c.CF.Org.Name = "otherOrg"
+				c.CF.Space.Guid = "otherSpaceGuid"
+				c.CF.Space.Name = "otherSpace"
+
+				appA, err := mocks.FileToString("appA.json")
+				Expect(err).ToNot(HaveOccurred())
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids=otherSpaceGuid").Return(appA, nil)
+
+				otherSpace, err := mocks.FileToString("commonSpace.json")
+				Expect(err).ToNot(HaveOccurred())
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces/otherSpaceGuid").Return(otherSpace, nil)
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/apps/"+AppAGuid,
+					"-X",
+					"PATCH",
+					lifecycleRequest(StackBName),
+				).Return([]string{}, nil)
+
+				cfHome := GinkgoT().TempDir()
+				Expect(os.MkdirAll(filepath.Join(cfHome, ".cf"), 0700)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(cfHome, ".cf", "config.json"), []byte("{}"), 0600)).To(Succeed())
+				GinkgoT().Setenv("CF_HOME", cfHome)
+
+				var targetEnv []string
+				gomock.InOrder(
+					mockRunner.EXPECT().RunWithEnv("cf", ".", true, gomock.Any(), "target", "-o", "otherOrg", "-s", "otherSpace").DoAndReturn(
+						func(_, _ string, _ bool, env []string, _ ...string) error {
+							targetEnv = env
+							return nil
+						}),
+					mockRunner.EXPECT().RunWithOutputEnv("cf", ".", true, gomock.Any(), "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+						func(_, _ string, _ bool, env []string, _ ...string) (string, error) {
+							Expect(env).To(Equal(targetEnv))
+							return "", nil
+						}),
+				)
+
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+					"curl",
+					"/v3/apps/"+AppAGuid+"/actions/start",
+					"-X",
+					"POST",
+				).Return([]string{}, nil)
+
+				_, err = c.ChangeStack(AppAName, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(targetEnv).To(HaveLen(1))
+				Expect(targetEnv[0]).To(HavePrefix("CF_HOME="))
+				Expect(targetEnv[0]).NotTo(Equal("CF_HOME=" + cfHome))
+				Expect(os.Getenv("CF_HOME")).To(Equal(cfHome))
+			})
+		})
+
+		When("json output is requested", func() {
+			BeforeEach(func() {
+				c.OutputType = changer.JSONFlag
+			})
+
+			It("returns a result object describing the change", func() {
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+				mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+				out, err := c.ChangeStack(AppAName, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+
+				var result changer.Result
+				Expect(json.Unmarshal([]byte(out), &result)).To(Succeed())
+				Expect(result.AppGUID).To(Equal(AppAGuid))
+				Expect(result.Space).To(Equal(mocks.SpaceName))
+				Expect(result.OldStack).To(Equal(StackAName))
+				Expect(result.NewStack).To(Equal(StackBName))
+				Expect(result.OldDropletGUID).To(Equal(AppADropletGuid))
+				Expect(result.NewDropletGUID).To(Equal(AppADropletGuid))
+				Expect(result.RestoredState).To(Equal("STARTED"))
+				Expect(result.DurationsMS).To(HaveKey(changer.StepRestaged))
+				Expect(result.Success).To(BeTrue())
+				Expect(result.FailureCategory).To(BeEmpty())
+			})
+
+			It("categorizes failures", func() {
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+				mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).Return("", errors.New("restage failed"))
+				expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+				out, err := c.ChangeStack(AppAName, StackBName)
+				Expect(err).To(HaveOccurred())
+				Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryRestage))
+
+				var result changer.Result
+				Expect(json.Unmarshal([]byte(out), &result)).To(Succeed())
+				Expect(result.Success).To(BeFalse())
+				Expect(result.RolledBack).To(BeTrue())
+				Expect(result.FailureCategory).To(Equal(changer.CategoryRestage))
+				Expect(result.Error).To(ContainSubstring("restage failed"))
+			})
+		})
+
+		It("groups the failures of a bulk change by category", func() {
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).
+				Return("ERROR: No match found for python 2.7.18 on stackB", errors.New("exit status 1"))
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+			Expect(result).To(ContainSubstring(changer.FailuresByCategoryMsg))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.CategoryGroupMsg, changer.CategoryDependencyUnavailable, 1, AppAName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyOnStackMsg, AppBName, StackBName)))
+		})
+
+		When("hooks are configured", func() {
+			BeforeEach(func() {
+				c.Hooks = changer.Hooks{Pre: "/hooks/pre", Post: "/hooks/post"}
+			})
+
+			It("does not change the app when the pre hook vetoes it", func() {
+				var env []string
+				mockRunner.EXPECT().RunWithEnv("/hooks/pre", ".", false, gomock.Any()).DoAndReturn(
+					func(bin, dir string, quiet bool, hookEnv []string, args ...string) error {
+						env = hookEnv
+						return errors.New("exit status 1")
+					})
+
+				_, err := c.ChangeStack(AppAName, StackBName)
+				Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryVetoed))
+				Expect(err).To(MatchError(fmt.Sprintf(changer.HookVetoError, changer.PreHook, "/hooks/pre", "exit status 1")))
+				Expect(env).To(ContainElement("STACK_AUDITOR_APP_GUID=" + AppAGuid))
+			})
+
+			It("runs the post hook with the outcome of the migration", func() {
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+
+				envs := map[string][]string{}
+				recordEnv := func(bin, dir string, quiet bool, env []string, args ...string) error {
+					envs[bin] = env
+					return nil
+				}
+				gomock.InOrder(
+					mockRunner.EXPECT().RunWithEnv("/hooks/pre", ".", false, gomock.Any()).DoAndReturn(recordEnv),
+					mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName),
+					mockRunner.EXPECT().RunWithEnv("/hooks/post", ".", false, gomock.Any()).DoAndReturn(
+						func(bin, dir string, quiet bool, env []string, args ...string) error {
+							recordEnv(bin, dir, quiet, env)
+							return errors.New("exit status 2")
+						}),
+				)
+
+				_, err := c.ChangeStack(AppAName, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(envs["/hooks/pre"]).To(ContainElement("STACK_AUDITOR_OLD_STACK=" + StackAName))
+				Expect(envs["/hooks/pre"]).NotTo(ContainElement(HavePrefix("STACK_AUDITOR_RESULT=")))
+				Expect(envs["/hooks/post"]).To(ContainElement("STACK_AUDITOR_RESULT=success"))
+				Expect(os.Getenv("STACK_AUDITOR_RESULT")).To(BeEmpty())
+			})
+		})
+
+		It("labels the app with the stack it was migrated from", func() {
+			var body string
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", mocks.HasPrefix(`-d={"metadata":`)).
+				Do(func(args ...string) { body = args[4] }).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+			c.Version = "1.2.3"
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+			_, err := c.ChangeStack(AppAName, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+
+			var request struct {
+				Metadata resources.Metadata `json:"metadata"`
+			}
+			Expect(json.Unmarshal([]byte(body[len("-d="):]), &request)).To(Succeed())
+			Expect(request.Metadata.Label(resources.MigratedFromLabel)).To(Equal(StackAName))
+			Expect(request.Metadata.Annotation(resources.MigratedByAnnotation)).To(Equal("stack-auditor 1.2.3"))
+			Expect(request.Metadata.Annotation(resources.MigratedFromDropletAnnotation)).To(Equal(AppADropletGuid))
+			Expect(request.Metadata.Annotation(resources.MigratedFromStateAnnotation)).To(Equal("STARTED"))
+			Expect(time.Parse(time.RFC3339, request.Metadata.Annotation(resources.MigratedAtAnnotation))).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+
+		When("apps opt out of migration", func() {
+			override := func(path, fixture string) {
+				out, err := mocks.FileToString(fixture)
+				Expect(err).ToNot(HaveOccurred())
+
+				mockConnection = mocks.NewMockCliConnection(mockCtrl)
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", path).Return(out, nil).AnyTimes()
+				mocks.ExpectDefaultResponses(mockConnection)
+				c.CF.Conn = mockConnection
+			}
+
+			It("leaves apps annotated to skip migration out of bulk runs", func() {
+				override("/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid, "appAOptedOut.json")
+
+				result, err := c.ChangeStacks([]string{AppAName}, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(ContainSubstring(fmt.Sprintf(changer.OptedOutError, "app annotation: vendor certification pending (until 2099-12-31)")))
+				Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ExcludedSummaryMsg, 1)))
+			})
+
+			It("leaves every app in a space annotated to skip migration out of bulk runs", func() {
+				override("/v3/spaces/"+mocks.SpaceGuid, "commonSpaceOptedOut.json")
+
+				result, err := c.ChangeStacks([]string{AppAName}, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(ContainSubstring(fmt.Sprintf(changer.OptedOutError, "space annotation: payments freeze")))
+			})
+
+			It("migrates apps whose opt-out has expired", func() {
+				override("/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid, "appAOptOutExpired.json")
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+				mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+				mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+				result, err := c.ChangeStacks([]string{AppAName}, StackBName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 1, 1, StackBName)))
+			})
+		})
+
+		It("refuses to change apps that use a docker image", func() {
+			dockerApp, err := mocks.FileToString("dockerApp.json")
+			Expect(err).ToNot(HaveOccurred())
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names=dockerApp&space_guids="+mocks.SpaceGuid).Return(dockerApp, nil)
+
+			_, err = c.ChangeStack("dockerApp", StackBName)
+			Expect(err).To(MatchError(changer.DockerAppError))
+		})
+
+		It("keeps the lifecycle type and buildpacks of cloud native buildpack apps", func() {
+			cnbApp, err := mocks.FileToString("appACnb.json")
+			Expect(err).ToNot(HaveOccurred())
+			cnbAppByGuid, err := mocks.FileToString("appACnbByGuid.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid).Return(cnbApp, nil).AnyTimes()
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid).Return(cnbAppByGuid, nil).AnyTimes()
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid,
+				"-X",
+				"PATCH",
+				`-d={"lifecycle":{"type":"cnb","data":{"buildpacks":["docker://some-registry/some-cnb","some-buildpack"],"stack":"`+StackBName+`"}}}`,
+			).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+			result, err := c.ChangeStack(AppAName, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		})
+
+		It("reports every failed pre-flight check before changing anything", func() {
+			expiredPackages, err := mocks.FileToString("expiredPackages.json")
+			Expect(err).ToNot(HaveOccurred())
+			fullOrgUsage, err := mocks.FileToString("fullOrgUsage.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/packages?order_by=-created_at&per_page=1").Return(expiredPackages, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations/"+mocks.OrgGuid+"/usage_summary").Return(fullOrgUsage, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+
+			_, err = c.ChangeStack(AppAName, "stackX")
+			Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryPreflight))
+			Expect(err.Error()).To(HavePrefix(fmt.Sprintf(changer.PreflightError, AppAName, "")))
+			Expect(err.Error()).To(ContainSubstring("stackX is not a valid stack"))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.BuildpackDisabledReason, "some-buildpack", "stackX")))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.PackageNotReadyReason, "expiredPackageGuid", "EXPIRED")))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.QuotaHeadroomReason, "org", "default", 140, 256)))
+		})
+
+		It("groups pre-flight failures of a bulk change under the category of the failed check", func() {
+			expiredPackages, err := mocks.FileToString("expiredPackages.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/packages?order_by=-created_at&per_page=1").Return(expiredPackages, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+
+			result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.CategoryGroupMsg, changer.CategoryPackageMissing, 1, AppAName)))
+			Expect(result).To(ContainSubstring("Hint: push the app again to upload a new package"))
+		})
+
+		It("groups a bulk change blocked by a full quota under quota_exceeded", func() {
+			fullOrgUsage, err := mocks.FileToString("fullOrgUsage.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations/"+mocks.OrgGuid+"/usage_summary").Return(fullOrgUsage, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+			c.OutputType = changer.JSONFlag
+
+			out, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+
+			var results []changer.Result
+			Expect(json.Unmarshal([]byte(out), &results)).To(Succeed())
+			Expect(results[0].FailureCategory).To(Equal(changer.CategoryQuotaExceeded))
+			Expect(results[0].Hint).To(ContainSubstring("raise its quota"))
+			Expect(results[1].Unchanged).To(BeTrue())
+		})
+
+		It("succeeds without changes when the app and its droplet are already on the stack", func() {
+			result, err := c.ChangeStack(AppAName, StackAName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(fmt.Sprintf(changer.AlreadyOnStackMsg, AppAName, StackAName)))
+		})
+
+		It("finishes the restage of an app assigned the stack whose droplet is not built on it", func() {
+			appADroplet, err := mocks.FileToString("appADroplet.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/droplets/current").Return(appADroplet, nil).AnyTimes()
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/actions/stop", "-X", "POST").Return([]string{}, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+			c.OutputType = changer.JSONFlag
+			var logged []string
+			c.Log = func(w io.Writer, msg string) {
+				logged = append(logged, msg)
+			}
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppBName)
+
+			out, err := c.ChangeStack(AppBName, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logged).To(ContainElement(fmt.Sprintf(changer.FinishingMigrationMsg+"\n", AppBName, StackAName)))
+
+			var result changer.Result
+			Expect(json.Unmarshal([]byte(out), &result)).To(Succeed())
+			Expect(result.Success).To(BeTrue())
+			Expect(result.Unchanged).To(BeFalse())
+			Expect(result.OldStack).To(Equal(StackAName))
+			Expect(result.DurationsMS).To(HaveKey(changer.StepRestaged))
+		})
+
+		It("finishes the restage of an app assigned the stack before it was ever staged", func() {
+			dropletNotFound, err := mocks.FileToString("dropletNotFound.json")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockConnection = mocks.NewMockCliConnection(mockCtrl)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/droplets/current").Return(dropletNotFound, nil).AnyTimes()
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/actions/stop", "-X", "POST").Return([]string{}, nil)
+			mocks.ExpectDefaultResponses(mockConnection)
+			c.CF.Conn = mockConnection
+			var logged []string
+			c.Log = func(w io.Writer, msg string) {
+				logged = append(logged, msg)
+			}
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppBName)
+
+			result, err := c.ChangeStack(AppBName, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppBName, StackBName)))
+			Expect(logged).To(ContainElement(fmt.Sprintf(changer.FinishingUnstagedMsg+"\n", AppBName)))
+		})
+	})
+
+	When("running rollback-stack", func() {
+		It("restores the most recent droplet from another stack and restarts the app", func() {
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid,
+				"-X",
+				"PATCH",
+				lifecycleRequest(StackBName),
+			).Return([]string{}, nil)
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid+"/relationships/current_droplet",
+				"-X",
+				"PATCH",
+				`-d={"data":{"guid":"`+AppAPreviousDropletGuid+`"}}`,
+			).Return([]string{}, nil)
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/deployments",
+				"-X",
+				"POST",
+				`-d={"droplet":{"guid":"`+AppAPreviousDropletGuid+`"},"strategy":"rolling","relationships":{"app":{"data":{"guid":"`+AppAGuid+`"}}}}`,
+			).Return([]string{}, nil)
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid+"/actions/start",
+				"-X",
+				"POST",
+			).Return([]string{}, nil)
+
+			result, err := c.RollbackStack(AppAName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(fmt.Sprintf(changer.RollbackStackSuccessMsg, AppAName, StackBName, AppAPreviousDropletGuid)))
 		})
 
-		It("returns an error when given the stack that the app is on", func() {
-			_, err := c.ChangeStack(AppAName, StackAName)
-			Expect(err).To(MatchError("application is already associated with stack " + StackAName))
+		It("returns an error when there is no droplet on another stack", func() {
+			_, err := c.RollbackStack(AppBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.NoPreviousDropletError, AppBName, StackBName)))
 		})
 	})
 })
+
+func expectRollback(appGuid, stackName, dropletGuid string) {
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+		"curl",
+		"/v3/apps/"+appGuid,
+		"-X",
+		"PATCH",
+		lifecycleRequest(stackName),
+	).Return([]string{}, nil)
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+		"curl",
+		"/v3/apps/"+appGuid+"/relationships/current_droplet",
+		"-X",
+		"PATCH",
+		`-d={"data":{"guid":"`+dropletGuid+`"}}`,
+	).Return([]string{}, nil)
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+		"curl",
+		"/v3/deployments",
+		"-X",
+		"POST",
+		`-d={"droplet":{"guid":"`+dropletGuid+`"},"strategy":"rolling","relationships":{"app":{"data":{"guid":"`+appGuid+`"}}}}`,
+	).Return([]string{}, nil)
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+		"curl",
+		"/v3/apps/"+appGuid+"/actions/start",
+		"-X",
+		"POST",
+	).Return([]string{}, nil)
+}
+
+func lifecycleRequest(stackName string) string {
+	return `-d={"lifecycle":{"type":"buildpack","data":{"buildpacks":["some-buildpack"],"stack":"` + stackName + `"}}}`
+}
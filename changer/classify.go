@@ -0,0 +1,126 @@
+package changer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Failure categories assigned by matching staging output and CC errors
+const (
+	CategoryStackUnsupported      = "stack_unsupported"
+	CategoryNoBuildpackDetected   = "no_buildpack_detected"
+	CategoryDependencyUnavailable = "dependency_unavailable"
+	CategoryStagingTimeout        = "staging_timeout"
+	CategoryQuotaExceeded         = "quota_exceeded"
+	CategoryPackageMissing        = "package_missing"
+)
+
+const (
+	HintMsg               = "Hint: %s"
+	FailuresByCategoryMsg = "Failures by category:"
+	CategoryGroupMsg      = "  %s (%d): %s"
+	CategoryHintMsg       = "    %s"
+)
+
+type classification struct {
+	category string
+	patterns []string
+	hint     string
+}
+
+// classifications are tried in order, so more specific patterns come first
+var classifications = []classification{
+	{
+		category: CategoryStackUnsupported,
+		patterns: []string{"does not support this stack", "not supported by this buildpack", "stack not supported", "unsupported stack", "not compatible with stack"},
+		hint:     "upload a version of the buildpack that supports the target stack, or switch the app to one that does",
+	},
+	{
+		category: CategoryNoBuildpackDetected,
+		patterns: []string{"none of the buildpacks detected", "noappdetectederror"},
+		hint:     "set the app's buildpack explicitly, or install and enable a buildpack for its language on the target stack",
+	},
+	{
+		category: CategoryDependencyUnavailable,
+		patterns: []string{"no match found for", "could not get dependency", "dependency not found", "not available for this stack", "unable to install"},
+		hint:     "pin a dependency version the buildpack provides for the target stack, or upload a buildpack version that includes it",
+	},
+	{
+		category: CategoryStagingTimeout,
+		patterns: []string{"stagingtimeexpired", "staging time expired", "staging timed out", "timed out"},
+		hint:     "retry the migration; if staging keeps timing out, raise the staging timeout or reduce what the app installs at staging",
+	},
+	{
+		category: CategoryQuotaExceeded,
+		patterns: []string{"quota", "insufficientresources", "insufficient resources"},
+		hint:     "free memory in the space or org, or raise its quota, then retry",
+	},
+	{
+		category: CategoryPackageMissing,
+		patterns: []string{"no package", "package is missing", "package has expired", "packagebitserror", "package not found"},
+		hint:     "push the app again to upload a new package, then retry",
+	},
+}
+
+// classify attributes err to the first category whose patterns appear in the
+// error or in the staging output behind it, or to fallback if none match
+func classify(fallback string, err error) error {
+	text := err.Error()
+	var stagingErr *StagingError
+	if errors.As(err, &stagingErr) {
+		text += "\n" + stagingErr.Output
+	}
+	text = strings.ToLower(text)
+
+	for _, class := range classifications {
+		for _, pattern := range class.patterns {
+			if strings.Contains(text, pattern) {
+				return &StepError{Category: class.category, Hint: class.hint, Err: err}
+			}
+		}
+	}
+	return failure(fallback, err)
+}
+
+// categoryHint returns the remediation hint of a category assigned by
+// classification
+func categoryHint(category string) string {
+	for _, class := range classifications {
+		if class.category == category {
+			return class.hint
+		}
+	}
+	return ""
+}
+
+// groupFailures lists the failed apps under their failure category, in the
+// order each category first failed, with the remediation hint for each
+func groupFailures(results []Result) []string {
+	var categories []string
+	apps := map[string][]string{}
+	hints := map[string]string{}
+	for _, result := range results {
+		if result.Success || result.FailureCategory == CategoryOptedOut {
+			continue
+		}
+		if _, ok := apps[result.FailureCategory]; !ok {
+			categories = append(categories, result.FailureCategory)
+		}
+		apps[result.FailureCategory] = append(apps[result.FailureCategory], result.AppName)
+		hints[result.FailureCategory] = result.Hint
+	}
+
+	if len(categories) == 0 {
+		return nil
+	}
+
+	lines := []string{FailuresByCategoryMsg}
+	for _, category := range categories {
+		lines = append(lines, fmt.Sprintf(CategoryGroupMsg, category, len(apps[category]), strings.Join(apps[category], ", ")))
+		if hints[category] != "" {
+			lines = append(lines, fmt.Sprintf(CategoryHintMsg, fmt.Sprintf(HintMsg, hints[category])))
+		}
+	}
+	return lines
+}
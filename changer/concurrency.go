@@ -0,0 +1,130 @@
+package changer
+
+import "fmt"
+
+const InvalidConcurrencyError = "invalid concurrency limit %d for %s: the limit cannot be negative"
+
+// Concurrency limits how many apps a bulk run changes at once, overall and
+// within each org and space. A zero per-org or per-space limit only applies
+// the overall limit, Max, which defaults to changing one app at a time.
+type Concurrency struct {
+	Max      int
+	PerOrg   int
+	PerSpace int
+}
+
+// NewConcurrency validates the limits given on the command line
+func NewConcurrency(parallel, perOrg, perSpace int) (Concurrency, error) {
+	limits := []struct {
+		flag  string
+		limit int
+	}{{"--parallel", parallel}, {"--max-per-org", perOrg}, {"--max-per-space", perSpace}}
+	for _, l := range limits {
+		if l.limit < 0 {
+			return Concurrency{}, fmt.Errorf(InvalidConcurrencyError, l.limit, l.flag)
+		}
+	}
+	return Concurrency{Max: parallel, PerOrg: perOrg, PerSpace: perSpace}, nil
+}
+
+func (c Concurrency) parallel() bool {
+	return c.Max > 1
+}
+
+// admits reports whether another app in org and space may start
+func (c Concurrency) admits(running int, inOrg, inSpace int) bool {
+	return running < max(c.Max, 1) &&
+		(c.PerOrg == 0 || inOrg < c.PerOrg) &&
+		(c.PerSpace == 0 || inSpace < c.PerSpace)
+}
+
+type bulkOutcome struct {
+	index  int
+	result Result
+}
+
+// runWave changes the apps of a wave, starting each as soon as the
+// concurrency limits allow and keeping the wave's order among apps that may
+// start. Once interrupted no more apps start, and the apps of the wave and
+// the later ones are listed as not started. Each app is changed by a copy of
+// c so that concurrent apps do not share a target space.
+func (c *Changer) runWave(wave []bulkApp, later []string) ([]Result, int) {
+	outcomes := make([]*Result, len(wave))
+	pending := make([]int, len(wave))
+	for i := range wave {
+		pending[i] = i
+	}
+
+	done := make(chan bulkOutcome)
+	running := 0
+	inOrg, inSpace := map[string]int{}, map[string]int{}
+	notStarted := 0
+
+	for len(pending) > 0 || running > 0 {
+		for notStarted == 0 && len(pending) > 0 {
+			next := -1
+			for i, index := range pending {
+				app := wave[index]
+				if c.Concurrency.admits(running, inOrg[app.org], inSpace[app.spaceKey()]) {
+					next = i
+					break
+				}
+			}
+			if next < 0 {
+				break
+			}
+
+			c.awaitWindow()
+			if notStarted = c.stopIfInterrupted(append(pendingNames(wave, pending), later...)); notStarted > 0 {
+				pending = nil
+				break
+			}
+
+			index := pending[next]
+			pending = append(pending[:next], pending[next+1:]...)
+			app := wave[index]
+
+			if !c.Concurrency.parallel() {
+				result, _ := app.change(c)
+				outcomes[index] = &result
+				continue
+			}
+
+			running++
+			inOrg[app.org]++
+			inSpace[app.spaceKey()]++
+			worker := *c
+			go func() {
+				result, _ := app.change(&worker)
+				done <- bulkOutcome{index: index, result: result}
+			}()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		outcome := <-done
+		app := wave[outcome.index]
+		running--
+		inOrg[app.org]--
+		inSpace[app.spaceKey()]--
+		outcomes[outcome.index] = &outcome.result
+	}
+
+	var results []Result
+	for _, outcome := range outcomes {
+		if outcome != nil {
+			results = append(results, *outcome)
+		}
+	}
+	return results, notStarted
+}
+
+func pendingNames(wave []bulkApp, pending []int) []string {
+	var names []string
+	for _, index := range pending {
+		names = append(names, wave[index].name)
+	}
+	return names
+}
@@ -0,0 +1,236 @@
+package changer_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/resources"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrency", func() {
+	var appBStarted chan struct{}
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockRunner = NewMockRunner(mockCtrl)
+		appBStarted = make(chan struct{})
+
+		appA, err := mocks.FileToString("appAOptOutExpired.json")
+		Expect(err).ToNot(HaveOccurred())
+		appB, err := mocks.FileToString("appB.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid).Return(appA, nil).AnyTimes()
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppBName+"&space_guids="+mocks.SpaceGuid).DoAndReturn(
+			func(args ...string) ([]string, error) {
+				close(appBStarted)
+				return appB, nil
+			})
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Org: plugin_models.Organization{
+					OrganizationFields: plugin_models.OrganizationFields{Name: "commonOrg"},
+				},
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Log:    func(w io.Writer, msg string) {},
+			LogDir: GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("changes apps at the same time up to the parallel limit", func() {
+		c.Concurrency = changer.Concurrency{Max: 2}
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, ...string) (string, error) {
+				select {
+				case <-appBStarted:
+					return "", nil
+				case <-time.After(5 * time.Second):
+					return "", errors.New("appB did not start while appA was restaging")
+				}
+			})
+
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyOnStackMsg, AppBName, StackBName)))
+		Expect(result).To(MatchRegexp(AppAName + ".*\n.*" + AppBName))
+	})
+
+	It("takes turns on the plugin connection while restaging at the same time", func() {
+		c.Concurrency = changer.Concurrency{Max: 2}
+		c.CF.Conn = &exclusiveConnection{CliConnection: mockConnection}
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, ...string) (string, error) {
+				select {
+				case <-appBStarted:
+					return "", nil
+				case <-time.After(5 * time.Second):
+					return "", errors.New("appB did not start while appA was restaging")
+				}
+			})
+
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 2, 2, StackBName)))
+	})
+
+	It("keeps to the per-space limit", func() {
+		c.Concurrency = changer.Concurrency{Max: 2, PerSpace: 1}
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, ...string) (string, error) {
+				select {
+				case <-appBStarted:
+					return "", errors.New("appB started while appA was restaging")
+				case <-time.After(50 * time.Millisecond):
+					return "", nil
+				}
+			})
+
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+	})
+
+	It("keeps to the per-org limit", func() {
+		c.Concurrency = changer.Concurrency{Max: 2, PerOrg: 1}
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, ...string) (string, error) {
+				select {
+				case <-appBStarted:
+					return "", errors.New("appB started while appA was restaging")
+				case <-time.After(50 * time.Millisecond):
+					return "", nil
+				}
+			})
+
+		_, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("NewConcurrency", func() {
+	It("rejects negative limits", func() {
+		_, err := changer.NewConcurrency(4, -1, 0)
+		Expect(err).To(MatchError(fmt.Sprintf(changer.InvalidConcurrencyError, -1, "--max-per-org")))
+	})
+})
+
+// exclusiveConnection fails a command started while another is running, as
+// the output of both would be mixed up by the plugin's RPC connection
+type exclusiveConnection struct {
+	plugin.CliConnection
+	running int32
+}
+
+func (e *exclusiveConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	defer atomic.AddInt32(&e.running, -1)
+	if atomic.AddInt32(&e.running, 1) > 1 {
+		return nil, errors.New("command overlapped another on the plugin connection")
+	}
+
+	time.Sleep(time.Millisecond)
+	return e.CliConnection.CliCommandWithoutTerminalOutput(args...)
+}
+
+var _ = Describe("Concurrency across spaces", func() {
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockRunner = NewMockRunner(mockCtrl)
+
+		appA, err := mocks.FileToString("appA.json")
+		Expect(err).ToNot(HaveOccurred())
+		otherSpace, err := mocks.FileToString("spacesV3.json")
+		Expect(err).ToNot(HaveOccurred())
+		otherSpaceMetadata, err := mocks.FileToString("commonSpace.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces?names=otherSpace&organization_guids="+mocks.OrgGuid).Return(otherSpace, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids=otherSpaceGuid").Return(appA, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces/otherSpaceGuid").Return(otherSpaceMetadata, nil).AnyTimes()
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil).Times(2)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil).Times(2)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		cfHome := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(cfHome, ".cf"), 0700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(cfHome, ".cf", "config.json"), []byte("{}"), 0600)).To(Succeed())
+		GinkgoT().Setenv("CF_HOME", cfHome)
+
+		c = changer.Changer{
+			Runner:      mockRunner,
+			CF:          cf.CF{Conn: mockConnection},
+			Log:         func(w io.Writer, msg string) {},
+			LogDir:      GinkgoT().TempDir(),
+			Concurrency: changer.Concurrency{Max: 2},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("restages apps in a space the CLI does not target at the same time as others", func() {
+		targetedStarted, otherStarted := make(chan struct{}), make(chan struct{})
+		await := func(started chan struct{}, app string) error {
+			select {
+			case <-started:
+				return nil
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("the restage of %s did not overlap", app)
+			}
+		}
+
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, ...string) (string, error) {
+				close(targetedStarted)
+				return "", await(otherStarted, "otherSpace/"+AppAName)
+			})
+		mockRunner.EXPECT().RunWithEnv("cf", ".", true, gomock.Any(), "target", "-o", "commonOrg", "-s", "otherSpace")
+		mockRunner.EXPECT().RunWithOutputEnv("cf", ".", true, gomock.Any(), "restage", "--strategy", "rolling", AppAName).DoAndReturn(
+			func(string, string, bool, []string, ...string) (string, error) {
+				close(otherStarted)
+				return "", await(targetedStarted, mocks.SpaceName+"/"+AppAName)
+			})
+
+		result, err := c.ChangeListedStacks(resources.Apps{
+			{Org: "commonOrg", Space: mocks.SpaceName, Name: AppAName},
+			{Org: "commonOrg", Space: "otherSpace", Name: AppAName},
+		}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 2, 2, StackBName)))
+	})
+})
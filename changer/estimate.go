@@ -0,0 +1,284 @@
+package changer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	EstimatingMsg          = "Estimating the change of %d apps to Stack %s...\n\n"
+	AppEstimateMsg         = "%s: %d instances, %s to stage, %s to roll out, %d MB extra memory"
+	NotEstimatedMsg        = "%s: not estimated, %s"
+	AlreadyOnStackReason   = "already on Stack %s"
+	EstimatedDurationMsg   = "Estimated duration: %s"
+	PeakMemoryMsg          = "Peak extra memory in org %s: %d MB"
+	EstimateAssumptionsMsg = "Assumes %s to stage apps without staging history, %s to start each instance and up to %d apps changed at a time"
+	DefaultStagingEstimate = 2 * time.Minute
+	DefaultStartEstimate   = 30 * time.Second
+	StagingHistory         = 5
+)
+
+// Estimate projects how long a bulk run will take and how much extra memory
+// its restages need in each org at their peak
+type Estimate struct {
+	Apps         []AppEstimate  `json:"apps"`
+	DurationMS   int64          `json:"duration_ms"`
+	PeakMemoryMB map[string]int `json:"peak_extra_memory_mb"`
+}
+
+// AppEstimate projects the change of one app. Apps that would not be
+// changed give the reason and take no time.
+type AppEstimate struct {
+	AppName       string `json:"app_name"`
+	Org           string `json:"org"`
+	Space         string `json:"space"`
+	Wave          int    `json:"wave"`
+	State         string `json:"state,omitempty"`
+	Instances     int    `json:"instances"`
+	StagingMS     int64  `json:"staging_ms"`
+	RolloutMS     int64  `json:"rollout_ms"`
+	DurationMS    int64  `json:"duration_ms"`
+	ExtraMemoryMB int    `json:"extra_memory_mb"`
+	Skipped       string `json:"skipped,omitempty"`
+}
+
+func (e AppEstimate) duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+func (e AppEstimate) String() string {
+	if e.Skipped != "" {
+		return fmt.Sprintf(NotEstimatedMsg, e.AppName, e.Skipped)
+	}
+	return fmt.Sprintf(AppEstimateMsg, e.AppName, e.Instances, roundDuration(e.StagingMS), roundDuration(e.RolloutMS), e.ExtraMemoryMB)
+}
+
+// estimate looks up every app of the waves without changing any, and
+// projects the run by scheduling the apps under the concurrency limits as
+// runWave would. Waves run one after another, so their durations add up and
+// each org needs the largest of its peaks.
+func (c *Changer) estimate(waves [][]bulkApp, newStack string, beforeWave func(wave int)) (string, error) {
+	total := 0
+	for _, wave := range waves {
+		total += len(wave)
+	}
+	c.printf(EstimatingMsg, total, newStack)
+
+	estimate := Estimate{Apps: []AppEstimate{}, PeakMemoryMB: map[string]int{}}
+	var duration time.Duration
+	for i, wave := range waves {
+		if beforeWave != nil {
+			beforeWave(i)
+		}
+
+		var apps []AppEstimate
+		for _, app := range wave {
+			e := c.estimateApp(app, newStack)
+			e.Wave = i + 1
+			apps = append(apps, e)
+		}
+
+		waveDuration, peaks := c.project(wave, apps)
+		duration += waveDuration
+		for org, peak := range peaks {
+			estimate.PeakMemoryMB[org] = max(estimate.PeakMemoryMB[org], peak)
+		}
+		estimate.Apps = append(estimate.Apps, apps...)
+	}
+	estimate.DurationMS = duration.Milliseconds()
+
+	if c.OutputType == JSONFlag {
+		out, err := json.Marshal(estimate)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+
+	var lines []string
+	for _, app := range estimate.Apps {
+		lines = append(lines, app.String())
+	}
+	lines = append(lines, fmt.Sprintf(EstimatedDurationMsg, roundDuration(estimate.DurationMS)))
+
+	var orgs []string
+	for org := range estimate.PeakMemoryMB {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		lines = append(lines, fmt.Sprintf(PeakMemoryMsg, org, estimate.PeakMemoryMB[org]))
+	}
+	lines = append(lines, fmt.Sprintf(EstimateAssumptionsMsg, DefaultStagingEstimate, DefaultStartEstimate, max(c.Concurrency.Max, 1)))
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// estimateApp projects the change of an app from its processes and recent
+// builds. Staging takes as long as the app's recent builds did on average.
+// A started app then rolls out one instance at a time and is verified,
+// while a stopped app, or one restarted, starts all its instances at once.
+func (c *Changer) estimateApp(app bulkApp, newStack string) AppEstimate {
+	e := AppEstimate{AppName: app.name, Org: app.org, Space: app.space}
+	skip := func(err error) AppEstimate {
+		e.Skipped = err.Error()
+		return e
+	}
+
+	if app.target != nil {
+		if err := app.target(c); err != nil {
+			return skip(err)
+		}
+	}
+
+	v3App, err := c.CF.GetAppByName(app.name)
+	if err != nil {
+		return skip(err)
+	}
+	e.State = v3App.State
+
+	if err := c.checkOptOut(v3App); err != nil {
+		return skip(err)
+	}
+	if err := checkLifecycle(v3App.Lifecycle.Type); err != nil {
+		return skip(err)
+	}
+	oldStack := v3App.Lifecycle.Data.Stack
+	if oldStack == newStack {
+		droplet, err := c.CF.GetCurrentDroplet(v3App.GUID)
+		switch {
+		case errors.Is(err, cf.ErrNoCurrentDroplet):
+		case err != nil:
+			return skip(err)
+		case droplet.Stack == newStack:
+			return skip(fmt.Errorf(AlreadyOnStackReason, newStack))
+		default:
+			oldStack = droplet.Stack
+		}
+	}
+	if err := c.checkListed(oldStack); err != nil {
+		return skip(err)
+	}
+
+	processes, err := c.CF.GetAppProcesses(v3App.GUID)
+	if err != nil {
+		return skip(err)
+	}
+	builds, err := c.CF.GetStagedBuilds(v3App.GUID, StagingHistory)
+	if err != nil {
+		return skip(err)
+	}
+
+	for _, process := range processes {
+		e.Instances += process.Instances
+	}
+
+	staging := stagingEstimate(builds)
+	rollout := DefaultStartEstimate
+	duration := staging
+	e.ExtraMemoryMB = restageMemory(v3App.State, processes)
+	if v3App.State == "STARTED" {
+		if c.Strategy == planner.StrategyRestart {
+			e.ExtraMemoryMB = 0
+		} else {
+			rollout = time.Duration(max(e.Instances, 1)) * DefaultStartEstimate
+		}
+		duration += c.Verification.Duration
+	}
+	duration += rollout
+
+	e.StagingMS = staging.Milliseconds()
+	e.RolloutMS = rollout.Milliseconds()
+	e.DurationMS = duration.Milliseconds()
+	return e
+}
+
+// stagingEstimate averages how long the builds took to stage
+func stagingEstimate(builds []resources.Build) time.Duration {
+	var total time.Duration
+	staged := 0
+	for _, build := range builds {
+		if d := build.Duration(); d > 0 {
+			total += d
+			staged++
+		}
+	}
+	if staged == 0 {
+		return DefaultStagingEstimate
+	}
+	return total / time.Duration(staged)
+}
+
+// project schedules the estimated apps of a wave the way runWave starts
+// them, and returns when the last one finishes and the most extra memory
+// the apps changing at the same time need in each org
+func (c *Changer) project(wave []bulkApp, estimates []AppEstimate) (time.Duration, map[string]int) {
+	type slot struct {
+		index int
+		end   time.Duration
+	}
+
+	pending := make([]int, len(wave))
+	for i := range wave {
+		pending[i] = i
+	}
+
+	var now time.Duration
+	var running []slot
+	inOrg, inSpace := map[string]int{}, map[string]int{}
+	memory, peaks := map[string]int{}, map[string]int{}
+
+	for len(pending) > 0 || len(running) > 0 {
+		for len(pending) > 0 {
+			next := -1
+			for i, index := range pending {
+				app := wave[index]
+				if c.Concurrency.admits(len(running), inOrg[app.org], inSpace[app.spaceKey()]) {
+					next = i
+					break
+				}
+			}
+			if next < 0 {
+				break
+			}
+
+			index := pending[next]
+			pending = append(pending[:next], pending[next+1:]...)
+			app := wave[index]
+			running = append(running, slot{index: index, end: now + estimates[index].duration()})
+			inOrg[app.org]++
+			inSpace[app.spaceKey()]++
+			memory[app.org] += estimates[index].ExtraMemoryMB
+			peaks[app.org] = max(peaks[app.org], memory[app.org])
+		}
+
+		first := 0
+		for i, s := range running {
+			if s.end < running[first].end {
+				first = i
+			}
+		}
+		done := running[first]
+		running = append(running[:first], running[first+1:]...)
+		now = done.end
+
+		app := wave[done.index]
+		inOrg[app.org]--
+		inSpace[app.spaceKey()]--
+		memory[app.org] -= estimates[done.index].ExtraMemoryMB
+	}
+
+	return now, peaks
+}
+
+func roundDuration(ms int64) time.Duration {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Second)
+}
@@ -0,0 +1,113 @@
+package changer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/planner"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Estimate", func() {
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockRunner = NewMockRunner(mockCtrl)
+
+		stagedBuilds, err := mocks.FileToString("stagedBuilds.json")
+		Expect(err).ToNot(HaveOccurred())
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/builds?app_guids="+AppAGuid+"&states=STAGED&order_by=-created_at&per_page=5").Return(stagedBuilds, nil).AnyTimes()
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Org: plugin_models.Organization{
+					OrganizationFields: plugin_models.OrganizationFields{Name: "commonOrg"},
+				},
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Log: func(w io.Writer, msg string) {
+				logMsg = msg
+			},
+			Estimate: true,
+			LogDir:   GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("adds up the apps changed one at a time without changing them", func() {
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, mocks.StackEName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AppEstimateMsg, AppAName, 1, 90*time.Second, 30*time.Second, 256)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AppEstimateMsg, AppBName, 1, changer.DefaultStagingEstimate, changer.DefaultStartEstimate, 256)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.EstimatedDurationMsg, 4*time.Minute+30*time.Second)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.PeakMemoryMsg, "commonOrg", 256)))
+	})
+
+	It("overlaps the apps changed at the same time", func() {
+		c.Concurrency = changer.Concurrency{Max: 2}
+
+		result, err := c.ChangeStacks([]string{AppAName, AppBName}, mocks.StackEName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.EstimatedDurationMsg, 2*time.Minute+30*time.Second)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.PeakMemoryMsg, "commonOrg", 512)))
+	})
+
+	It("counts verification and needs no extra memory to restart a started app", func() {
+		c.OutputType = changer.JSONFlag
+		c.Strategy = planner.StrategyRestart
+		c.Verification.Duration = time.Minute
+
+		result, err := c.ChangeStacks([]string{AppAName}, mocks.StackEName)
+		Expect(err).NotTo(HaveOccurred())
+
+		var estimate changer.Estimate
+		Expect(json.Unmarshal([]byte(result), &estimate)).To(Succeed())
+		Expect(estimate.Apps).To(HaveLen(1))
+		Expect(estimate.Apps[0].DurationMS).To(Equal((3 * time.Minute).Milliseconds()))
+		Expect(estimate.Apps[0].ExtraMemoryMB).To(Equal(0))
+		Expect(estimate.DurationMS).To(Equal((3 * time.Minute).Milliseconds()))
+		Expect(estimate.PeakMemoryMB).To(Equal(map[string]int{"commonOrg": 0}))
+	})
+
+	It("estimates the restage of an app assigned the stack before it was ever staged", func() {
+		dropletNotFound, err := mocks.FileToString("dropletNotFound.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid+"/droplets/current").Return(dropletNotFound, nil).AnyTimes()
+		mocks.ExpectDefaultResponses(mockConnection)
+		c.CF.Conn = mockConnection
+
+		result, err := c.ChangeStacks([]string{AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AppEstimateMsg, AppBName, 1, changer.DefaultStagingEstimate, changer.DefaultStartEstimate, 256)))
+	})
+
+	It("leaves out apps that would not be changed", func() {
+		result, err := c.ChangeStacks([]string{AppBName}, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.NotEstimatedMsg, AppBName, fmt.Sprintf(changer.AlreadyOnStackReason, StackBName))))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.EstimatedDurationMsg, time.Duration(0))))
+	})
+})
@@ -0,0 +1,70 @@
+package changer
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RunningHookMsg    = "Running %s hook %s for %s\n"
+	HookVetoError     = "%s hook %s vetoed the migration: %v"
+	PostHookFailedMsg = "Warning: post hook %s failed for %s: %v\n"
+	PreHook           = "pre"
+	PostHook          = "post"
+)
+
+// Hooks are executables run before and after each app's stack change. They
+// receive the migration in STACK_AUDITOR_* environment variables. A pre hook
+// that exits non-zero stops the app's migration before anything is changed.
+type Hooks struct {
+	Pre  string
+	Post string
+}
+
+// withHooks runs the pre hook, the migration and then the post hook, which
+// also learns whether the migration succeeded
+func (c *Changer) withHooks(m *Migration, migrate func() (Result, error)) (Result, error) {
+	if c.Hooks.Pre != "" {
+		if err := c.runHook(PreHook, c.Hooks.Pre, m, nil); err != nil {
+			err = failure(CategoryVetoed, fmt.Errorf(HookVetoError, PreHook, c.Hooks.Pre, err))
+			result := newResult(*m)
+			result.finish(err)
+			return result, err
+		}
+	}
+
+	result, err := migrate()
+
+	if c.Hooks.Post != "" {
+		if hookErr := c.runHook(PostHook, c.Hooks.Post, m, err); hookErr != nil {
+			c.printf(PostHookFailedMsg, c.Hooks.Post, m.AppName, hookErr)
+		}
+	}
+
+	return result, err
+}
+
+// runHook runs a hook with the migration in its own environment, leaving the
+// plugin's environment, and so that of later hooks and restages, unchanged
+func (c *Changer) runHook(phase, hook string, m *Migration, migrateErr error) error {
+	c.printf(RunningHookMsg, phase, hook, m.AppName)
+
+	env := []string{
+		"STACK_AUDITOR_HOOK=" + phase,
+		"STACK_AUDITOR_APP_GUID=" + m.AppGUID,
+		"STACK_AUDITOR_APP_NAME=" + m.AppName,
+		"STACK_AUDITOR_ORG=" + m.Org,
+		"STACK_AUDITOR_SPACE=" + m.Space,
+		"STACK_AUDITOR_OLD_STACK=" + m.OldStack,
+		"STACK_AUDITOR_NEW_STACK=" + m.NewStack,
+	}
+	if phase == PostHook {
+		outcome, errMsg := "success", ""
+		if migrateErr != nil {
+			outcome, errMsg = "failure", strings.SplitN(migrateErr.Error(), "\n", 2)[0]
+		}
+		env = append(env, "STACK_AUDITOR_RESULT="+outcome, "STACK_AUDITOR_ERROR="+errMsg)
+	}
+
+	return c.Runner.RunWithEnv(hook, ".", c.OutputType == JSONFlag, env)
+}
@@ -0,0 +1,88 @@
+package changer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	ActiveDeploymentReason  = "deployment %s is still active (%s)"
+	StagingBuildReason      = "build %s is still staging"
+	InFlightWaitMsg         = "Waiting up to %s for %s to finish: %s"
+	InFlightError           = "app is busy: %s"
+	InFlightTimeoutError    = "app is still busy after waiting %s: %s"
+	InFlightHint            = "retry once the app's deployment or staging has finished, or pass --wait-in-flight <duration> to wait for it"
+	DefaultInFlightInterval = 5 * time.Second
+)
+
+// InFlight configures how long to wait for an app's own deployments and
+// builds, such as those of a push in progress, before changing its stack. A
+// zero Wait skips busy apps straight away.
+type InFlight struct {
+	Wait     time.Duration
+	Interval time.Duration
+}
+
+// awaitIdle waits until the app has no active deployment and no build in
+// STAGING, so that the restage does not collide with them. An app still
+// busy when the wait is over is skipped with the reason.
+func (c *Changer) awaitIdle(app resources.V3App) error {
+	interval := c.InFlight.Interval
+	if interval <= 0 {
+		interval = DefaultInFlightInterval
+	}
+
+	deadline := time.Now().Add(c.InFlight.Wait)
+	waiting := false
+	for {
+		busy, err := c.inFlight(app.GUID)
+		if err != nil {
+			return failure(CategoryInFlight, fmt.Errorf(CheckFailedReason, "deployments and builds", err))
+		}
+		if len(busy) == 0 {
+			return nil
+		}
+
+		reasons := strings.Join(busy, ", ")
+		if c.InFlight.Wait <= 0 {
+			return &StepError{Category: CategoryInFlight, Hint: InFlightHint, Err: fmt.Errorf(InFlightError, reasons)}
+		}
+		if !time.Now().Before(deadline) {
+			return &StepError{Category: CategoryInFlight, Hint: InFlightHint, Err: fmt.Errorf(InFlightTimeoutError, c.InFlight.Wait, reasons)}
+		}
+		if err := c.interrupted("the app's deployments and builds finished"); err != nil {
+			return err
+		}
+
+		if !waiting {
+			c.printf(InFlightWaitMsg+"\n", c.InFlight.Wait, app.Name, reasons)
+			waiting = true
+		}
+		time.Sleep(min(interval, time.Until(deadline)))
+	}
+}
+
+// inFlight lists the app's active deployments and staging builds
+func (c *Changer) inFlight(appGUID string) ([]string, error) {
+	deployments, err := c.CF.GetActiveDeployments(appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := c.CF.GetStagingBuilds(appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var busy []string
+	for _, deployment := range deployments {
+		busy = append(busy, fmt.Sprintf(ActiveDeploymentReason, deployment.GUID, deployment.Status.Reason))
+	}
+	for _, build := range builds {
+		busy = append(busy, fmt.Sprintf(StagingBuildReason, build.GUID))
+	}
+	return busy, nil
+}
@@ -0,0 +1,98 @@
+package changer_test
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InFlight", func() {
+	var activeDeployments, stagingBuilds, emptyList []string
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockRunner = NewMockRunner(mockCtrl)
+
+		var err error
+		activeDeployments, err = mocks.FileToString("activeDeployments.json")
+		Expect(err).ToNot(HaveOccurred())
+		stagingBuilds, err = mocks.FileToString("stagingBuilds.json")
+		Expect(err).ToNot(HaveOccurred())
+		emptyList, err = mocks.FileToString("emptyListV3.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Log: func(w io.Writer, msg string) {
+				logMsg = msg
+			},
+			InFlight: changer.InFlight{Interval: time.Millisecond},
+			LogDir:   GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("skips an app with an active deployment without waiting", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/deployments?app_guids="+AppAGuid+"&status_values=ACTIVE").Return(activeDeployments, nil)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryInFlight))
+		Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.InFlightError, fmt.Sprintf(changer.ActiveDeploymentReason, "activeDeploymentGuid", "DEPLOYING"))))
+		Expect(err.Error()).To(ContainSubstring(changer.InFlightHint))
+	})
+
+	It("waits for a staging build to finish before changing the stack", func() {
+		c.InFlight.Wait = time.Minute
+		gomock.InOrder(
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/builds?app_guids="+AppAGuid+"&states=STAGING").Return(stagingBuilds, nil),
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/builds?app_guids="+AppAGuid+"&states=STAGING").Return(emptyList, nil),
+		)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		var logged []string
+		c.Log = func(w io.Writer, msg string) {
+			logged = append(logged, msg)
+		}
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logged).To(ContainElement(fmt.Sprintf(changer.InFlightWaitMsg+"\n", time.Minute, AppAName, fmt.Sprintf(changer.StagingBuildReason, "stagingBuildGuid"))))
+	})
+
+	It("skips an app that is still busy when the wait is over", func() {
+		c.InFlight.Wait = 5 * time.Millisecond
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/builds?app_guids="+AppAGuid+"&states=STAGING").Return(stagingBuilds, nil).MinTimes(2)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryInFlight))
+		Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.InFlightTimeoutError, 5*time.Millisecond, fmt.Sprintf(changer.StagingBuildReason, "stagingBuildGuid"))))
+	})
+})
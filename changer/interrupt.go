@@ -0,0 +1,95 @@
+package changer
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	InterruptReceivedMsg = "Interrupt received; finishing or reverting the current step before stopping"
+	InterruptedError     = "interrupted before %s"
+	NotStartedMsg        = "%s: not started because the run was interrupted"
+	VerifyInterruptedMsg = "Verification interrupted; leaving the app on its new stack"
+	InterruptedRunError  = "interrupted with %d apps not started"
+)
+
+// Interrupts records whether SIGINT or SIGTERM has been received so that a
+// Changer can stop at the next safe point instead of dying mid-migration
+type Interrupts struct {
+	signals  chan os.Signal
+	received atomic.Bool
+}
+
+// NotifyInterrupts starts catching SIGINT and SIGTERM
+func NotifyInterrupts() *Interrupts {
+	i := &Interrupts{signals: make(chan os.Signal, 1)}
+	signal.Notify(i.signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for range i.signals {
+			i.Trigger()
+		}
+	}()
+
+	return i
+}
+
+// Trigger marks the run as interrupted
+func (i *Interrupts) Trigger() {
+	if !i.received.Swap(true) {
+		fmt.Fprintln(os.Stderr, InterruptReceivedMsg)
+	}
+}
+
+func (i *Interrupts) Received() bool {
+	return i != nil && i.received.Load()
+}
+
+// Stop restores the default signal handling
+func (i *Interrupts) Stop() {
+	if i.signals != nil {
+		signal.Stop(i.signals)
+		close(i.signals)
+	}
+}
+
+func (c *Changer) interrupted(next string) error {
+	if !c.Interrupts.Received() {
+		return nil
+	}
+	return failure(CategoryInterrupted, fmt.Errorf(InterruptedError, next))
+}
+
+// stopIfInterrupted lists the apps that a bulk run will not start because it
+// was interrupted and returns how many there are
+func (c *Changer) stopIfInterrupted(remaining []string) int {
+	if !c.Interrupts.Received() {
+		return 0
+	}
+
+	for _, appName := range remaining {
+		c.printf(NotStartedMsg+"\n", appName)
+	}
+	return len(remaining)
+}
+
+func withNotStarted(err error, notStarted int) error {
+	if notStarted == 0 {
+		return err
+	}
+	if err == nil {
+		return failure(CategoryInterrupted, fmt.Errorf(InterruptedRunError, notStarted))
+	}
+	return failure(CategoryInterrupted, fmt.Errorf("%w; "+InterruptedRunError, err, notStarted))
+}
+
+func entryNames(entries []JournalEntry) []string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.AppName
+	}
+	return names
+}
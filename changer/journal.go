@@ -0,0 +1,232 @@
+package changer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/utils"
+)
+
+// Steps recorded in the journal, in the order a migration passes through them
+const (
+	StepPlanned           = "planned"
+	StepRecorded          = "recorded"
+	StepStackAssigned     = "stack_assigned"
+	StepRestaged          = "restaged"
+	StepProcessesRestored = "processes_restored"
+	StepStateRestored     = "state_restored"
+	StepCompleted         = "completed"
+	StepFailed            = "failed"
+	StepReverted          = "reverted"
+
+	// StepUnchanged records an app found already on its new stack, which
+	// needs neither a resume nor a revert
+	StepUnchanged = "unchanged"
+)
+
+// Outcomes of the rollback of a failed migration
+const (
+	RollbackSucceeded = "succeeded"
+	RollbackFailed    = "failed"
+)
+
+const ErrorReadingJournal = "problem reading journal %s"
+
+var stepOrder = []string{StepPlanned, StepRecorded, StepStackAssigned, StepRestaged, StepProcessesRestored, StepStateRestored, StepCompleted}
+
+// Migration describes an app's stack change and what the app looked like
+// before it started, which is everything needed to resume or undo it
+type Migration struct {
+	AppGUID     string       `json:"app_guid,omitempty"`
+	AppName     string       `json:"app_name"`
+	Org         string       `json:"org"`
+	Space       string       `json:"space"`
+	SpaceGUID   string       `json:"space_guid"`
+	OldStack    string       `json:"old_stack,omitempty"`
+	NewStack    string       `json:"new_stack"`
+	State       string       `json:"state,omitempty"`
+	DropletGUID string       `json:"droplet_guid,omitempty"`
+	Snapshot    *AppSnapshot `json:"snapshot,omitempty"`
+	Rollout     *Rollout     `json:"rollout,omitempty"`
+
+	// stackAssigned is set when the app was already assigned NewStack
+	// before the migration started
+	stackAssigned bool
+
+	// reached is the last step the migration completed, and rollback the
+	// outcome of rolling it back after a failure, if it was rolled back
+	reached  string
+	rollback string
+}
+
+func (m Migration) key() string {
+	return m.SpaceGUID + "/" + m.AppName
+}
+
+// JournalEntry is one line of the journal. A failed entry also holds the
+// last step the migration reached before it failed, and the outcome of its
+// rollback if one was attempted.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	Migration
+	Step     string `json:"step"`
+	Reached  string `json:"reached,omitempty"`
+	Rollback string `json:"rollback,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// lastDone returns the last step the migration of the entry completed
+func (e JournalEntry) lastDone() string {
+	if e.Step == StepFailed {
+		return e.Reached
+	}
+	return e.Step
+}
+
+// changed reports whether the app of the entry may have been left on its
+// new stack, so that it needs a revert
+func (e JournalEntry) changed() bool {
+	return e.Rollback != RollbackSucceeded && stepDone(e.lastDone(), StepStackAssigned)
+}
+
+// Journal is an append-only JSON lines file recording the progress of every
+// migration performed by a Changer
+type Journal struct {
+	Path  string
+	lines *utils.JSONLines
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	lines, err := utils.OpenJSONLines(path, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{Path: path, lines: lines}, nil
+}
+
+func (j *Journal) Record(m Migration, step string, stepErr error) error {
+	entry := JournalEntry{
+		Time:      time.Now().UTC(),
+		Migration: m,
+		Step:      step,
+	}
+	if stepErr != nil {
+		entry.Error = stepErr.Error()
+	}
+	return j.write(entry)
+}
+
+// RecordFailure records that the migration failed after reaching a step,
+// and the outcome of its rollback if one was attempted
+func (j *Journal) RecordFailure(m Migration, reached, rollback string, stepErr error) error {
+	return j.write(JournalEntry{
+		Time:      time.Now().UTC(),
+		Migration: m,
+		Step:      StepFailed,
+		Reached:   reached,
+		Rollback:  rollback,
+		Error:     stepErr.Error(),
+	})
+}
+
+func (j *Journal) write(entry JournalEntry) error {
+	return j.lines.Append(entry)
+}
+
+func (j *Journal) Close() error {
+	return j.lines.Close()
+}
+
+// ReadJournal returns the latest entry for every app in the journal, in the
+// order the apps first appear. An app found unchanged by a repeated run keeps
+// the entry of the migration that changed it, so that it can be reverted.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf(ErrorReadingJournal+": %w", path, err)
+	}
+	defer file.Close()
+
+	var order []string
+	latest := make(map[string]JournalEntry)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf(ErrorReadingJournal+": line %d: %w", path, line, err)
+		}
+
+		key := entry.key()
+		previous, ok := latest[key]
+		if !ok {
+			order = append(order, key)
+		} else if entry.Step == StepUnchanged && previous.changed() {
+			continue
+		}
+		latest[key] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf(ErrorReadingJournal+": %w", path, err)
+	}
+
+	entries := make([]JournalEntry, 0, len(order))
+	for _, key := range order {
+		entries = append(entries, latest[key])
+	}
+	return entries, nil
+}
+
+// stepDone reports whether a migration whose last recorded step is done has
+// already passed step
+func stepDone(done, step string) bool {
+	return stepIndex(done) >= stepIndex(step)
+}
+
+func stepIndex(step string) int {
+	for i, s := range stepOrder {
+		if s == step {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Changer) record(m Migration, step string, stepErr error) {
+	if c.Journal == nil {
+		return
+	}
+
+	if err := c.Journal.Record(m, step, stepErr); err != nil {
+		c.warnJournal(err)
+	}
+}
+
+// advance records that the migration completed step
+func (c *Changer) advance(m *Migration, step string) {
+	m.reached = step
+	c.record(*m, step, nil)
+}
+
+func (c *Changer) recordFailure(m Migration, stepErr error) {
+	if c.Journal == nil {
+		return
+	}
+
+	if err := c.Journal.RecordFailure(m, m.reached, m.rollback, stepErr); err != nil {
+		c.warnJournal(err)
+	}
+}
+
+func (c *Changer) warnJournal(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: could not write to journal %s: %v\n", c.Journal.Path, err)
+}
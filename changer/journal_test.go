@@ -0,0 +1,404 @@
+package changer_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/cloudfoundry/stack-auditor/resources"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var journalPath string
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.SetupMockCliConnection(mockCtrl)
+		mockRunner = NewMockRunner(mockCtrl)
+		journalPath = filepath.Join(GinkgoT().TempDir(), "journal.jsonl")
+
+		journal, err := changer.OpenJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(journal.Close)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Org: plugin_models.Organization{
+					OrganizationFields: plugin_models.OrganizationFields{Name: "commonOrg"},
+				},
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Journal: journal,
+			LogDir:  GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	appAMigration := changer.Migration{
+		AppGUID:     AppAGuid,
+		AppName:     AppAName,
+		Org:         "commonOrg",
+		Space:       mocks.SpaceName,
+		SpaceGUID:   mocks.SpaceGuid,
+		OldStack:    StackAName,
+		NewStack:    StackBName,
+		State:       "STARTED",
+		DropletGUID: AppADropletGuid,
+	}
+
+	writeJournal := func(steps ...string) {
+		for _, step := range steps {
+			Expect(c.Journal.Record(appAMigration, step, nil)).To(Succeed())
+		}
+	}
+
+	It("records every step of a migration", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+			"curl",
+			"/v3/apps/"+AppAGuid,
+			"-X",
+			"PATCH",
+			lifecycleRequest(StackBName),
+		).Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := os.ReadFile(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		Expect(lines).To(HaveLen(6))
+		Expect(lines[0]).To(ContainSubstring(`"droplet_guid":"` + AppADropletGuid + `"`))
+		Expect(lines[5]).To(ContainSubstring(`"step":"completed"`))
+
+		entries, err := changer.ReadJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Step).To(Equal(changer.StepCompleted))
+		Expect(entries[0].Snapshot.Processes).To(HaveLen(1))
+		Expect(entries[0].Snapshot.Sidecars).To(HaveLen(1))
+
+		entries[0].Snapshot = nil
+		Expect(entries[0].Migration).To(Equal(appAMigration))
+	})
+
+	It("records the step a failed migration reached and the outcome of its rollback", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).Return("", errors.New("restage failed"))
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackAName)).Return(nil, errors.New("patch failed"))
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(err).To(HaveOccurred())
+
+		entries, err := changer.ReadJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].Step).To(Equal(changer.StepFailed))
+		Expect(entries[0].Reached).To(Equal(changer.StepStackAssigned))
+		Expect(entries[0].Rollback).To(Equal(changer.RollbackFailed))
+	})
+
+	It("records a failure after the restage, which is not rolled back", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return(nil, errors.New("start failed"))
+
+		_, err := c.ChangeStack(AppAName, StackBName)
+		Expect(err).To(HaveOccurred())
+
+		entries, err := changer.ReadJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].Step).To(Equal(changer.StepFailed))
+		Expect(entries[0].Reached).To(Equal(changer.StepProcessesRestored))
+		Expect(entries[0].Rollback).To(BeEmpty())
+	})
+
+	When("resuming", func() {
+		It("continues an interrupted migration after its last completed step", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned)
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ResumeSummaryMsg, 1, 1)))
+		})
+
+		It("rolls out a migration of a plan the way its wave would have", func() {
+			appAMigration.Rollout = &changer.Rollout{From: StackAName, Strategy: planner.StrategyRestart}
+			DeferCleanup(func() { appAMigration.Rollout = nil })
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned)
+
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", AppAName)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+			Expect(c.Strategy).To(BeEmpty())
+		})
+
+		It("restores processes that were scaled or removed by the restage", func() {
+			appAMigration.Snapshot = &changer.AppSnapshot{
+				Processes: []resources.Process{
+					{Type: "web", Instances: 3, MemoryInMB: 256, DiskInMB: 1024, HealthCheck: resources.HealthCheck{Type: "port"}},
+					{Type: "worker", Instances: 2, MemoryInMB: 512, DiskInMB: 1024},
+				},
+			}
+			DeferCleanup(func() { appAMigration.Snapshot = nil })
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged)
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/processes/"+AppAWebProcessGuid+"/actions/scale",
+				"-X",
+				"POST",
+				`-d={"instances":3,"memory_in_mb":256,"disk_in_mb":1024}`,
+			).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+			_, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := changer.ReadJournal(journalPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries[0].Step).To(Equal(changer.StepCompleted))
+		})
+
+		It("continues a migration that failed after the restage", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepProcessesRestored)
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepProcessesRestored, "", errors.New("start failed"))).To(Succeed())
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		})
+
+		It("reports a migration whose rollback failed instead of resuming it", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned)
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepStackAssigned, changer.RollbackFailed, errors.New("restage failed"))).To(Succeed())
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.JournalFailureError, 1)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RollbackFailedMsg, AppAName, StackBName, "restage failed")))
+		})
+
+		It("skips migrations that failed and were rolled back", func() {
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepStackAssigned, changer.RollbackSucceeded, errors.New("restage failed"))).To(Succeed())
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.PreviouslyFailedMsg, AppAName, "restage failed")))
+		})
+
+		It("skips completed migrations", func() {
+			writeJournal(changer.StepRecorded, changer.StepCompleted)
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyCompletedMsg, AppAName, StackBName)))
+		})
+
+		It("skips apps that were already on their new stack", func() {
+			writeJournal(changer.StepPlanned, changer.StepUnchanged)
+
+			result, err := c.ResumeJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyOnStackMsg, AppAName, StackBName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ResumeSummaryMsg, 1, 1)))
+		})
+	})
+
+	When("reverting", func() {
+		It("returns changed apps to their original stack, droplet and state", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepCompleted)
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackAName, AppADropletGuid)))
+
+			entries, err := changer.ReadJournal(journalPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries[0].Step).To(Equal(changer.StepReverted))
+		})
+
+		It("returns a result for each app in json", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepCompleted)
+			Expect(c.Journal.Record(changer.Migration{AppName: AppBName, SpaceGUID: mocks.SpaceGuid, NewStack: StackAName}, changer.StepPlanned, nil)).To(Succeed())
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+			c.OutputType = changer.JSONFlag
+
+			out, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			var results []changer.Result
+			Expect(json.Unmarshal([]byte(out), &results)).To(Succeed())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].AppName).To(Equal(AppAName))
+			Expect(results[0].Success).To(BeTrue())
+			Expect(results[0].RolledBack).To(BeTrue())
+			Expect(results[0].NewDropletGUID).To(Equal(AppADropletGuid))
+			Expect(results[1].AppName).To(Equal(AppBName))
+			Expect(results[1].Unchanged).To(BeTrue())
+		})
+
+		It("reverts a migration whose rollback failed", func() {
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepStackAssigned, changer.RollbackFailed, errors.New("restage failed"))).To(Succeed())
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackAName, AppADropletGuid)))
+		})
+
+		It("reverts a migration that failed after the restage", func() {
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepProcessesRestored, "", errors.New("start failed"))).To(Succeed())
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackAName, AppADropletGuid)))
+		})
+
+		It("leaves migrations that were already rolled back alone", func() {
+			Expect(c.Journal.RecordFailure(appAMigration, changer.StepStackAssigned, changer.RollbackSucceeded, errors.New("restage failed"))).To(Succeed())
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.PreviouslyFailedMsg, AppAName, "restage failed")))
+		})
+
+		It("leaves apps whose stack was never changed alone", func() {
+			writeJournal(changer.StepRecorded)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.NothingToRevertMsg, AppAName)))
+		})
+
+		It("reports apps that were already reverted", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepCompleted, changer.StepReverted)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyRevertedMsg, AppAName, StackAName, AppADropletGuid)))
+			Expect(result).NotTo(ContainSubstring(fmt.Sprintf(changer.NothingToRevertMsg, AppAName)))
+		})
+
+		It("leaves processes scaled since the migration as they are", func() {
+			appAMigration.Snapshot = &changer.AppSnapshot{
+				Processes: []resources.Process{
+					{Type: "web", Instances: 3, MemoryInMB: 256, DiskInMB: 1024, HealthCheck: resources.HealthCheck{Type: "port"}},
+				},
+			}
+			DeferCleanup(func() { appAMigration.Snapshot = nil })
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepCompleted)
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackAName, AppADropletGuid)))
+		})
+
+		It("leaves apps that were already on their new stack alone", func() {
+			writeJournal(changer.StepPlanned, changer.StepUnchanged)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.NothingToRevertMsg, AppAName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSummaryMsg, 0, 1)))
+		})
+
+		It("reverts a migration that a repeated run found unchanged", func() {
+			writeJournal(changer.StepRecorded, changer.StepStackAssigned, changer.StepRestaged, changer.StepCompleted, changer.StepUnchanged)
+			expectRollback(AppAGuid, StackAName, AppADropletGuid)
+
+			result, err := c.RevertJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackAName, AppADropletGuid)))
+		})
+	})
+
+	When("interrupted", func() {
+		BeforeEach(func() {
+			c.Interrupts = &changer.Interrupts{}
+		})
+
+		It("reverts the stack of an app that was not yet restaged", func() {
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid,
+				"-X",
+				"PATCH",
+				lifecycleRequest(StackBName),
+			).Do(func(args ...string) { c.Interrupts.Trigger() }).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid,
+				"-X",
+				"PATCH",
+				lifecycleRequest(StackAName),
+			).Return([]string{}, nil)
+
+			_, err := c.ChangeStack(AppAName, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.InterruptedError, changer.StepRestaged)))
+			Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryInterrupted))
+
+			entries, err := changer.ReadJournal(journalPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries[0].Step).To(Equal(changer.StepRecorded))
+		})
+
+		It("finishes the app in flight and does not start the rest", func() {
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput(
+				"curl",
+				"/v3/apps/"+AppAGuid,
+				"-X",
+				"PATCH",
+				lifecycleRequest(StackBName),
+			).Return([]string{}, nil)
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName).
+				Do(func(bin, dir string, quiet bool, args ...string) { c.Interrupts.Trigger() })
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST")
+
+			result, err := c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.InterruptedRunError, 1)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 1, 2, StackBName)))
+
+			entries, err := changer.ReadJournal(journalPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries[1].AppName).To(Equal(AppBName))
+			Expect(entries[1].Step).To(Equal(changer.StepPlanned))
+		})
+	})
+})
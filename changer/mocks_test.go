@@ -51,6 +51,25 @@ func (mr *MockRunnerMockRecorder) Run(bin, dir, quiet interface{}, args ...inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockRunner)(nil).Run), varargs...)
 }
 
+// RunWithEnv mocks base method
+func (m *MockRunner) RunWithEnv(bin, dir string, quiet bool, env []string, args ...string) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{bin, dir, quiet, env}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RunWithEnv", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RunWithEnv indicates an expected call of RunWithEnv
+func (mr *MockRunnerMockRecorder) RunWithEnv(bin, dir, quiet, env interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{bin, dir, quiet, env}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWithEnv", reflect.TypeOf((*MockRunner)(nil).RunWithEnv), varargs...)
+}
+
 // RunWithOutput mocks base method
 func (m *MockRunner) RunWithOutput(bin, dir string, quiet bool, args ...string) (string, error) {
 	m.ctrl.T.Helper()
@@ -71,6 +90,26 @@ func (mr *MockRunnerMockRecorder) RunWithOutput(bin, dir, quiet interface{}, arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWithOutput", reflect.TypeOf((*MockRunner)(nil).RunWithOutput), varargs...)
 }
 
+// RunWithOutputEnv mocks base method
+func (m *MockRunner) RunWithOutputEnv(bin, dir string, quiet bool, env []string, args ...string) (string, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{bin, dir, quiet, env}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "RunWithOutputEnv", varargs...)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunWithOutputEnv indicates an expected call of RunWithOutputEnv
+func (mr *MockRunnerMockRecorder) RunWithOutputEnv(bin, dir, quiet, env interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{bin, dir, quiet, env}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWithOutputEnv", reflect.TypeOf((*MockRunner)(nil).RunWithOutputEnv), varargs...)
+}
+
 // SetEnv mocks base method
 func (m *MockRunner) SetEnv(variableName, path string) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,24 @@
+package changer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const OptedOutError = "excluded from migration by %s"
+
+// checkOptOut returns an opted_out error when the app or its space is
+// annotated to be left out of bulk stack changes
+func (c *Changer) checkOptOut(app resources.V3App) error {
+	space, err := c.CF.GetSpaceMetadata(c.CF.Space.Guid)
+	if err != nil {
+		return failure(CategoryAppLookup, err)
+	}
+
+	if reason := resources.SkipReason(app.Metadata, space, time.Now()); reason != "" {
+		return failure(CategoryOptedOut, fmt.Errorf(OptedOutError, reason))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package changer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	ApplyingPlanMsg  = "Applying plan to change %d apps from Stack %s to %s in %d waves...\n\n"
+	ApplyingWaveMsg  = "Wave %d of %d (%s): %d apps, %s strategy\n\n"
+	NotOnListedStack = "app is on Stack %s, not %s as planned or listed"
+	StaleListHint    = "the app changed stack after it was planned or listed; plan or list it again"
+)
+
+// Rollout is how the wave of a plan changes its apps. It is journaled with
+// each migration of the plan, so that a resumed migration is rolled out the
+// way its wave would have.
+type Rollout struct {
+	From             string `json:"from"`
+	Strategy         string `json:"strategy"`
+	VerifyDurationMS int64  `json:"verify_duration_ms,omitempty"`
+	ProbeURL         string `json:"probe_url,omitempty"`
+	ProbeStatus      int    `json:"probe_status,omitempty"`
+}
+
+// ApplyPlan changes the stack of every app in the plan, one wave after
+// another, restaging and verifying each wave as the plan says. Like a bulk
+// run it continues past failures and honors opt-out annotations.
+func (c *Changer) ApplyPlan(plan planner.Plan) (string, error) {
+	if !c.Estimate {
+		c.printf(ApplyingPlanMsg, plan.Size(), plan.From, plan.To, len(plan.Waves))
+	}
+
+	rollout := func(wave planner.Wave) *Rollout {
+		return &Rollout{
+			From:             plan.From,
+			Strategy:         wave.Strategy,
+			VerifyDurationMS: wave.Verification().Milliseconds(),
+			ProbeURL:         wave.ProbeURL,
+			ProbeStatus:      wave.ProbeStatus,
+		}
+	}
+
+	targets := map[string]cf.CF{}
+	var waves [][]bulkApp
+	for _, wave := range plan.Waves {
+		var apps resources.Apps
+		for _, app := range wave.Apps {
+			apps = append(apps, resources.App{Org: app.Org, Space: app.Space, Name: app.Name, Stack: plan.From})
+		}
+
+		c.useRollout(rollout(wave))
+		waves = append(waves, c.listedApps(apps, plan.To, targets))
+	}
+
+	return c.runBulk(waves, plan.To, func(i int) {
+		wave := plan.Waves[i]
+		c.printf(ApplyingWaveMsg, i+1, len(plan.Waves), wave.Name, len(wave.Apps), wave.Strategy)
+		c.useRollout(rollout(wave))
+	})
+}
+
+// useRollout changes apps the way a wave of a plan does
+func (c *Changer) useRollout(rollout *Rollout) {
+	c.Rollout = rollout
+	c.from = rollout.From
+	c.Strategy = rollout.Strategy
+	c.Verification.Duration = time.Duration(rollout.VerifyDurationMS) * time.Millisecond
+	c.Verification.ProbeURL = rollout.ProbeURL
+	c.Verification.ProbeStatus = rollout.ProbeStatus
+}
+
+// checkListed refuses to change an app of a plan or list that is no longer
+// on the stack it was planned or listed on
+func (c *Changer) checkListed(oldStack string) error {
+	if c.from == "" || oldStack == c.from {
+		return nil
+	}
+
+	return &StepError{
+		Category: CategoryStackChanged,
+		Hint:     StaleListHint,
+		Err:      fmt.Errorf(NotOnListedStack, oldStack, c.from),
+	}
+}
+
+// ChangeListedStacks changes the stack of each app in a list such as the
+// output of audit-stack, wherever the app lives. Apps listed with a stack
+// are only changed while they are still on it. It otherwise behaves like
+// ChangeStacks.
+func (c *Changer) ChangeListedStacks(apps resources.Apps, newStack string) (string, error) {
+	return c.runBulk(c.canaryWaves(c.listedApps(apps, newStack, map[string]cf.CF{})), newStack, nil)
+}
+
+// listedApps records each app, found by org and space, as planned. An app
+// with a stack is expected to be on it when it is changed.
+func (c *Changer) listedApps(apps resources.Apps, newStack string, targets map[string]cf.CF) []bulkApp {
+	var listed []bulkApp
+	for _, app := range apps {
+		err := c.targetListedSpace(app, targets)
+		if err == nil {
+			c.record(c.newMigration(app.Name, newStack), StepPlanned, nil)
+		}
+
+		org, space, from := c.CF.Org, c.CF.Space, app.Stack
+		target := func(w *Changer) error {
+			if err != nil {
+				return err
+			}
+			w.CF.Org, w.CF.Space, w.from = org, space, from
+			return nil
+		}
+		listed = append(listed, bulkApp{name: app.Name, org: app.Org, space: app.Space, target: target, change: func(w *Changer) (Result, error) {
+			if err := target(w); err != nil {
+				err := failure(CategoryAppLookup, err)
+				result := newResult(Migration{AppName: app.Name, Org: app.Org, Space: app.Space, NewStack: newStack})
+				result.finish(err)
+				return result, err
+			}
+
+			return w.changeStack(app.Name, newStack, true)
+		}})
+	}
+	return listed
+}
+
+// targetListedSpace points app lookups at the app's space, remembering each
+// space so it is only looked up once
+func (c *Changer) targetListedSpace(app resources.App, targets map[string]cf.CF) error {
+	key := app.Org + "/" + app.Space
+	if target, ok := targets[key]; ok {
+		c.CF.Org, c.CF.Space = target.Org, target.Space
+		return nil
+	}
+
+	if err := c.CF.TargetSpace(app.Org, app.Space); err != nil {
+		return err
+	}
+	targets[key] = c.CF
+	return nil
+}
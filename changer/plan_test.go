@@ -0,0 +1,206 @@
+package changer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/cloudfoundry/stack-auditor/resources"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Plan", func() {
+	var journalPath string
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockRunner = NewMockRunner(mockCtrl)
+		journalPath = filepath.Join(GinkgoT().TempDir(), "journal.jsonl")
+
+		journal, err := changer.OpenJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(journal.Close)
+
+		appA, err := mocks.FileToString("appAOptOutExpired.json")
+		Expect(err).ToNot(HaveOccurred())
+
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid).Return(appA, nil).AnyTimes()
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+			},
+			Journal: journal,
+			LogDir:  GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	plan := planner.Plan{
+		From: StackAName,
+		To:   StackBName,
+		Waves: []planner.Wave{
+			{
+				Name:        "first",
+				Strategy:    planner.StrategyRestart,
+				ProbeStatus: 200,
+				Apps:        []planner.App{{Org: "commonOrg", Space: mocks.SpaceName, Name: AppAName}},
+			},
+			{
+				Name:        "second",
+				Strategy:    planner.StrategyRolling,
+				ProbeStatus: 200,
+				Apps:        []planner.App{{Org: "commonOrg", Space: mocks.SpaceName, Name: AppBName}},
+			},
+		},
+	}
+
+	It("changes the apps wave by wave with each wave's strategy", func() {
+		gomock.InOrder(
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil),
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", AppAName),
+		)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+
+		result, err := c.ApplyPlan(plan)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyOnStackMsg, AppBName, StackBName)))
+		Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 2, 2, StackBName)))
+	})
+
+	It("records every app in the plan as planned before changing any", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", AppAName)
+
+		c.ApplyPlan(plan)
+
+		entries, err := changer.ReadJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].AppName).To(Equal(AppAName))
+		Expect(entries[0].Step).To(Equal(changer.StepCompleted))
+		Expect(entries[1].AppName).To(Equal(AppBName))
+		Expect(entries[1].SpaceGUID).To(Equal(mocks.SpaceGuid))
+		Expect(entries[1].Step).To(Equal(changer.StepUnchanged))
+	})
+
+	It("records the stack and rollout of each wave with its apps", func() {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+		mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", AppAName)
+
+		c.ApplyPlan(plan)
+
+		entries, err := changer.ReadJournal(journalPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[0].Rollout).To(Equal(&changer.Rollout{From: StackAName, Strategy: planner.StrategyRestart, ProbeStatus: 200}))
+		Expect(entries[1].Rollout).To(Equal(&changer.Rollout{From: StackAName, Strategy: planner.StrategyRolling, ProbeStatus: 200}))
+	})
+
+	It("refuses apps that are no longer on the stack the plan was made for", func() {
+		stale := plan
+		stale.From = "stackC"
+		c.OutputType = changer.JSONFlag
+
+		out, err := c.ApplyPlan(stale)
+		Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+
+		var results []changer.Result
+		Expect(json.Unmarshal([]byte(out), &results)).To(Succeed())
+		Expect(results[0].FailureCategory).To(Equal(changer.CategoryStackChanged))
+		Expect(results[0].Error).To(ContainSubstring(fmt.Sprintf(changer.NotOnListedStack, StackAName, "stackC")))
+		Expect(results[1].Unchanged).To(BeTrue())
+	})
+
+	When("changing the apps listed by audit-stack", func() {
+		audit := resources.Apps{
+			{Org: "commonOrg", Space: mocks.SpaceName, Name: AppAName, Stack: StackAName, State: "started"},
+			{Org: "commonOrg", Space: mocks.SpaceName, Name: AppBName, Stack: StackBName, State: "stopped"},
+		}
+
+		expectAppAChanged := func() {
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+		}
+
+		It("reads the csv output, with its columns reordered and added to", func() {
+			csv := "owner,name,space,org,stack\nalice,appA,commonSpace,commonOrg,stackA\r\n"
+			apps, err := resources.ReadApps(strings.NewReader(csv))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apps).To(Equal(resources.Apps{{Org: "commonOrg", Space: mocks.SpaceName, Name: AppAName, Stack: StackAName}}))
+
+			expectAppAChanged()
+			result, err := c.ChangeListedStacks(apps, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.BulkSummaryMsg, 1, 1, StackBName)))
+		})
+
+		It("reads the csv and json output unchanged", func() {
+			csv, err := audit.CSV()
+			Expect(err).NotTo(HaveOccurred())
+			fromCSV, err := resources.ReadApps(strings.NewReader(csv))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fromCSV).To(Equal(audit))
+
+			out, err := json.Marshal(audit)
+			Expect(err).NotTo(HaveOccurred())
+			fromJSON, err := resources.ReadApps(bytes.NewReader(out))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fromJSON).To(Equal(audit))
+		})
+
+		It("reads the text output and <org>/<space>/<app> lines once each", func() {
+			apps, err := resources.ReadApps(strings.NewReader(audit.String() + "# owners ticked off\ncommonOrg/commonSpace/appA\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apps).To(HaveLen(2))
+
+			expectAppAChanged()
+			result, err := c.ChangeListedStacks(apps, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.ChangeStackSuccessMsg, AppAName, StackBName)))
+			Expect(result).To(ContainSubstring(fmt.Sprintf(changer.AlreadyOnStackMsg, AppBName, StackBName)))
+		})
+
+		It("refuses apps no longer on the stack they were listed on", func() {
+			csv := "org,space,name,stack,state\ncommonOrg,commonSpace,appA,windows,started\ncommonOrg,commonSpace,appB,stackB,stopped\n"
+			apps, err := resources.ReadApps(strings.NewReader(csv))
+			Expect(err).NotTo(HaveOccurred())
+			c.OutputType = changer.JSONFlag
+
+			out, err := c.ChangeListedStacks(apps, StackBName)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.BulkFailureError, 1, 2, StackBName)))
+
+			var results []changer.Result
+			Expect(json.Unmarshal([]byte(out), &results)).To(Succeed())
+			Expect(results[0].FailureCategory).To(Equal(changer.CategoryStackChanged))
+			Expect(results[0].Error).To(ContainSubstring(fmt.Sprintf(changer.NotOnListedStack, StackAName, "windows")))
+			Expect(results[1].Unchanged).To(BeTrue())
+		})
+
+		It("rejects lists without apps or with malformed lines", func() {
+			_, err := resources.ReadApps(strings.NewReader("\n# nothing yet\n"))
+			Expect(err).To(MatchError(resources.NoAppsListedError))
+
+			_, err = resources.ReadApps(strings.NewReader("appA stackA\n"))
+			Expect(err).To(MatchError(fmt.Sprintf(resources.InvalidAppLineError, 1, "appA stackA")))
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package changer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ErrorReadingPolicy   = "problem reading migration policy %s: %v"
+	InvalidPolicyError   = "invalid migration policy %s: %s"
+	NoPathsReason        = "it allows no stack changes"
+	IncompletePathReason = "every allowed path needs a from and a to stack"
+	DisallowedPathError  = "changing from stack %s to %s is not allowed by migration policy %s"
+	AllowedPathsHint     = "the policy allows %s"
+)
+
+// Policy lists the stack changes that may be made. Any change it does not
+// list is refused before the app is touched.
+type Policy struct {
+	Path    string       `yaml:"-"`
+	Allowed []PolicyPath `yaml:"allowed"`
+}
+
+type PolicyPath struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (p PolicyPath) String() string {
+	return p.From + " -> " + p.To
+}
+
+func ReadPolicy(path string) (*Policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(ErrorReadingPolicy, path, err)
+	}
+
+	policy := &Policy{Path: path}
+	if err := yaml.UnmarshalStrict(buf, policy); err != nil {
+		return nil, fmt.Errorf(ErrorReadingPolicy, path, err)
+	}
+
+	if len(policy.Allowed) == 0 {
+		return nil, fmt.Errorf(InvalidPolicyError, path, NoPathsReason)
+	}
+	for _, allowed := range policy.Allowed {
+		if allowed.From == "" || allowed.To == "" {
+			return nil, fmt.Errorf(InvalidPolicyError, path, IncompletePathReason)
+		}
+	}
+	return policy, nil
+}
+
+// checkPolicy refuses a change from oldStack to newStack that the policy
+// does not allow. Without a policy every change is allowed, as is finishing
+// the restage of an app whose earlier stack is not known.
+func (c *Changer) checkPolicy(oldStack, newStack string) error {
+	if c.Policy == nil || oldStack == newStack {
+		return nil
+	}
+
+	var paths []string
+	for _, allowed := range c.Policy.Allowed {
+		if allowed.From == oldStack && allowed.To == newStack {
+			return nil
+		}
+		paths = append(paths, allowed.String())
+	}
+
+	return &StepError{
+		Category: CategoryDisallowedPath,
+		Hint:     fmt.Sprintf(AllowedPathsHint, strings.Join(paths, ", ")),
+		Err:      fmt.Errorf(DisallowedPathError, oldStack, newStack, c.Policy.Path),
+	}
+}
@@ -0,0 +1,90 @@
+package changer_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	writePolicy := func(contents string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "policy.yml")
+		Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		return path
+	}
+
+	When("changing stacks under a policy", func() {
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockConnection = mocks.SetupMockCliConnection(mockCtrl)
+			mockRunner = NewMockRunner(mockCtrl)
+
+			c = changer.Changer{
+				Runner: mockRunner,
+				CF: cf.CF{
+					Conn: mockConnection,
+					Space: plugin_models.Space{
+						SpaceFields: plugin_models.SpaceFields{
+							Guid: mocks.SpaceGuid,
+							Name: mocks.SpaceName,
+						},
+					},
+				},
+				Log: func(w io.Writer, msg string) {
+					logMsg = msg
+				},
+				LogDir: GinkgoT().TempDir(),
+			}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("changes stacks along an allowed path", func() {
+			var err error
+			c.Policy, err = changer.ReadPolicy(writePolicy("allowed:\n- from: " + StackAName + "\n  to: " + StackBName + "\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid, "-X", "PATCH", lifecycleRequest(StackBName)).Return([]string{}, nil)
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid+"/actions/start", "-X", "POST").Return([]string{}, nil)
+			mockRunner.EXPECT().RunWithOutput("cf", ".", true, "restage", "--strategy", "rolling", AppAName)
+
+			_, err = c.ChangeStack(AppAName, StackBName)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses a change the policy does not allow before touching the app", func() {
+			path := writePolicy("allowed:\n- from: " + StackAName + "\n  to: cflinuxfs4\n")
+			var err error
+			c.Policy, err = changer.ReadPolicy(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.ChangeStack(AppAName, StackBName)
+			Expect(changer.FailureCategory(err)).To(Equal(changer.CategoryDisallowedPath))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.DisallowedPathError, StackAName, StackBName, path)))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf(changer.AllowedPathsHint, StackAName+" -> cflinuxfs4")))
+		})
+	})
+
+	DescribeTable("rejects invalid policies",
+		func(contents, expected string) {
+			_, err := changer.ReadPolicy(writePolicy(contents))
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("with no allowed paths", "allowed: []\n", changer.NoPathsReason),
+		Entry("with a path missing its target", "allowed:\n- from: cflinuxfs3\n", changer.IncompletePathReason),
+		Entry("with unknown keys", "allow:\n- from: cflinuxfs3\n  to: cflinuxfs4\n", "field allow not found"),
+	)
+})
@@ -0,0 +1,147 @@
+package changer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	PreflightError          = "pre-flight checks failed for %s:\n  - %s"
+	CheckFailedReason       = "could not check %s: %v"
+	BuildpackMissingReason  = "buildpack %s is not installed"
+	BuildpackDisabledReason = "buildpack %s is not enabled for stack %s"
+	NoBuildpackReason       = "no buildpack is enabled for stack %s"
+	NoPackageReason         = "app has no package to restage from"
+	PackageNotReadyReason   = "latest package %s is %s, not READY"
+	QuotaHeadroomReason     = "%s quota %s has %dM of memory free; restaging needs %dM"
+)
+
+// failCheck records a failed pre-flight check under its failure category
+type failCheck func(category, reason string, args ...interface{})
+
+// preflight checks that the app can be restaged on newStack before anything
+// is changed, and reports every check that failed together. The error takes
+// the category and hint of the first check that failed, so that a missing
+// package or a full quota is reported as it would be if staging hit it.
+func (c *Changer) preflight(app resources.V3App, newStack string) error {
+	var reasons, categories []string
+	fail := func(category, reason string, args ...interface{}) {
+		categories = append(categories, category)
+		reasons = append(reasons, fmt.Sprintf(reason, args...))
+	}
+
+	if _, err := c.CF.GetStackGUID(newStack); err != nil {
+		fail(CategoryPreflight, "%v", err)
+	}
+
+	if app.Lifecycle.Type == "buildpack" {
+		if err := c.checkBuildpacks(app.Lifecycle.Data.Buildpacks, newStack, fail); err != nil {
+			fail(CategoryPreflight, CheckFailedReason, "buildpacks", err)
+		}
+	}
+
+	pkg, err := c.CF.GetLatestPackage(app.GUID)
+	switch {
+	case err != nil:
+		fail(CategoryPreflight, CheckFailedReason, "packages", err)
+	case pkg == nil:
+		fail(CategoryPackageMissing, NoPackageReason)
+	case pkg.State != "READY":
+		fail(CategoryPackageMissing, PackageNotReadyReason, pkg.GUID, pkg.State)
+	}
+
+	if err := c.checkQuotas(app, fail); err != nil {
+		fail(CategoryPreflight, CheckFailedReason, "quotas", err)
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+	return &StepError{
+		Category: categories[0],
+		Hint:     categoryHint(categories[0]),
+		Err:      fmt.Errorf(PreflightError, app.Name, strings.Join(reasons, "\n  - ")),
+	}
+}
+
+// checkBuildpacks requires every named system buildpack to be enabled for
+// the stack. Buildpacks given as URLs are fetched at staging and not checked.
+// An app without buildpacks needs at least one enabled buildpack to detect.
+func (c *Changer) checkBuildpacks(names []string, stack string, fail failCheck) error {
+	buildpackMetas, err := c.CF.GetAllBuildpacks()
+	if err != nil {
+		return err
+	}
+
+	installed := map[string]bool{}
+	enabled := map[string]bool{}
+	for _, meta := range buildpackMetas {
+		for _, buildpack := range meta.BuildPacks {
+			installed[buildpack.Entity.Name] = true
+			if buildpack.Entity.Enabled && (buildpack.Entity.Stack == stack || buildpack.Entity.Stack == "") {
+				enabled[buildpack.Entity.Name] = true
+			}
+		}
+	}
+
+	if len(names) == 0 && len(enabled) == 0 {
+		fail(CategoryStackUnsupported, NoBuildpackReason, stack)
+	}
+
+	for _, name := range names {
+		switch {
+		case strings.Contains(name, "://"):
+		case !installed[name]:
+			fail(CategoryStackUnsupported, BuildpackMissingReason, name)
+		case !enabled[name]:
+			fail(CategoryStackUnsupported, BuildpackDisabledReason, name, stack)
+		}
+	}
+	return nil
+}
+
+// checkQuotas requires the space and org quotas to have room for the memory
+// a restage adds
+func (c *Changer) checkQuotas(app resources.V3App, fail failCheck) error {
+	processes, err := c.CF.GetAppProcesses(app.GUID)
+	if err != nil {
+		return err
+	}
+
+	needed := restageMemory(app.State, processes)
+
+	quotas, err := c.CF.GetMemoryQuotas(c.CF.Space.Guid)
+	if err != nil {
+		return err
+	}
+
+	for _, quota := range quotas {
+		if quota.LimitInMB == nil {
+			continue
+		}
+		if free := *quota.LimitInMB - quota.UsedInMB; free < needed {
+			fail(CategoryQuotaExceeded, QuotaHeadroomReason, quota.Kind, quota.Name, free, needed)
+		}
+	}
+	return nil
+}
+
+// restageMemory returns the memory in MB a restage adds: one extra instance
+// of each process while a started app rolls, or every instance when a
+// stopped app is started to stage
+func restageMemory(state string, processes []resources.Process) int {
+	needed := 0
+	for _, process := range processes {
+		if process.Instances == 0 {
+			continue
+		}
+		if state == "STARTED" {
+			needed += process.MemoryInMB
+		} else {
+			needed += process.Instances * process.MemoryInMB
+		}
+	}
+	return needed
+}
@@ -0,0 +1,159 @@
+package changer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	ErrorSnapshottingApp    = "problem recording processes and sidecars of %s"
+	ErrorRestoringProcesses = "problem restoring processes and sidecars of %s"
+	ProcessMissingDrift     = "process %s no longer exists"
+	ProcessScaleDrift       = "process %s was scaled to %d instances, %dM memory, %dM disk; restored %d instances, %dM memory, %dM disk"
+	HealthCheckDrift        = "process %s health check was %s; restored %s"
+	SidecarMissingDrift     = "sidecar %s no longer exists; recreated it"
+	SidecarDrift            = "sidecar %s was changed; restored it"
+	DriftMsg                = "Restored drift in %s: %s"
+)
+
+// AppSnapshot is the configuration of an app's processes and sidecars that a
+// restage must not change
+type AppSnapshot struct {
+	Processes []resources.Process `json:"processes"`
+	Sidecars  []resources.Sidecar `json:"sidecars"`
+}
+
+type scaleRequest struct {
+	Instances  int `json:"instances"`
+	MemoryInMB int `json:"memory_in_mb"`
+	DiskInMB   int `json:"disk_in_mb"`
+}
+
+type healthCheckRequest struct {
+	HealthCheck resources.HealthCheck `json:"health_check"`
+}
+
+func (c *Changer) snapshot(appGUID string) (*AppSnapshot, error) {
+	processes, err := c.CF.GetAppProcesses(appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars, err := c.CF.GetAppSidecars(appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppSnapshot{Processes: processes, Sidecars: sidecars}, nil
+}
+
+// restoreProcesses compares the app's processes and sidecars to the snapshot,
+// restores anything that drifted and returns a description of each drift
+func (c *Changer) restoreProcesses(appGUID string, snapshot *AppSnapshot) ([]string, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	current, err := c.snapshot(appGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for _, want := range snapshot.Processes {
+		i := slices.IndexFunc(current.Processes, func(p resources.Process) bool { return p.Type == want.Type })
+		if i < 0 {
+			drift = append(drift, fmt.Sprintf(ProcessMissingDrift, want.Type))
+			continue
+		}
+		got := current.Processes[i]
+
+		if got.Instances != want.Instances || got.MemoryInMB != want.MemoryInMB || got.DiskInMB != want.DiskInMB {
+			if err := c.scaleProcess(got.GUID, want); err != nil {
+				return drift, err
+			}
+			drift = append(drift, fmt.Sprintf(ProcessScaleDrift, want.Type,
+				got.Instances, got.MemoryInMB, got.DiskInMB,
+				want.Instances, want.MemoryInMB, want.DiskInMB))
+		}
+
+		if !reflect.DeepEqual(got.HealthCheck, want.HealthCheck) {
+			if err := c.updateHealthCheck(got.GUID, want.HealthCheck); err != nil {
+				return drift, err
+			}
+			drift = append(drift, fmt.Sprintf(HealthCheckDrift, want.Type, got.HealthCheck.Type, want.HealthCheck.Type))
+		}
+	}
+
+	for _, want := range snapshot.Sidecars {
+		i := slices.IndexFunc(current.Sidecars, func(s resources.Sidecar) bool { return s.Name == want.Name })
+		if i < 0 {
+			if err := c.saveSidecar(appGUID, "/v3/apps/"+appGUID+"/sidecars", "POST", want); err != nil {
+				return drift, err
+			}
+			drift = append(drift, fmt.Sprintf(SidecarMissingDrift, want.Name))
+			continue
+		}
+		got := current.Sidecars[i]
+
+		if got.Command != want.Command || got.MemoryInMB != want.MemoryInMB || !slices.Equal(got.ProcessTypes, want.ProcessTypes) {
+			if err := c.saveSidecar(got.GUID, "/v3/sidecars/"+got.GUID, "PATCH", want); err != nil {
+				return drift, err
+			}
+			drift = append(drift, fmt.Sprintf(SidecarDrift, want.Name))
+		}
+	}
+
+	return drift, nil
+}
+
+func (c *Changer) scaleProcess(processGUID string, want resources.Process) error {
+	body, err := json.Marshal(scaleRequest{
+		Instances:  want.Instances,
+		MemoryInMB: want.MemoryInMB,
+		DiskInMB:   want.DiskInMB,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CF.Mutate(cf.ActionScaleProcess, processGUID, "/v3/processes/"+processGUID+"/actions/scale", "-X", "POST", "-d="+string(body))
+	return err
+}
+
+func (c *Changer) updateHealthCheck(processGUID string, healthCheck resources.HealthCheck) error {
+	body, err := json.Marshal(healthCheckRequest{HealthCheck: healthCheck})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CF.Mutate(cf.ActionUpdateHealthCheck, processGUID, "/v3/processes/"+processGUID, "-X", "PATCH", "-d="+string(body))
+	return err
+}
+
+func (c *Changer) saveSidecar(targetGUID, path, method string, sidecar resources.Sidecar) error {
+	sidecar.GUID = ""
+	body, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CF.Mutate(cf.ActionSaveSidecar, targetGUID, path, "-X", method, "-d="+string(body))
+	return err
+}
+
+func (c *Changer) reportDrift(m *Migration) ([]string, error) {
+	drift, err := c.restoreProcesses(m.AppGUID, m.Snapshot)
+	for _, d := range drift {
+		c.printf(DriftMsg+"\n", m.AppName, d)
+	}
+	if err != nil {
+		return drift, fmt.Errorf(ErrorRestoringProcesses+": %w", m.AppName, err)
+	}
+	return drift, nil
+}
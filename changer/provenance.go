@@ -0,0 +1,66 @@
+package changer
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	PluginName             = "stack-auditor"
+	ErrorRecordingMetadata = "Warning: could not update the migration labels of %s: %v\n"
+)
+
+type metadataRequest struct {
+	Metadata resources.Metadata `json:"metadata"`
+}
+
+// labelMigrated records on the app which stack it was migrated from, when,
+// and by which version of the plugin, and the droplet and state to revert to
+func (c *Changer) labelMigrated(m *Migration) {
+	migratedAt := time.Now().UTC().Format(time.RFC3339)
+	migratedBy := strings.TrimSpace(PluginName + " " + c.Version)
+
+	c.patchMetadata(m.AppGUID, m.AppName, resources.Metadata{
+		Labels: map[string]*string{
+			resources.MigratedFromLabel: &m.OldStack,
+		},
+		Annotations: map[string]*string{
+			resources.MigratedAtAnnotation:          &migratedAt,
+			resources.MigratedByAnnotation:          &migratedBy,
+			resources.MigratedFromDropletAnnotation: &m.DropletGUID,
+			resources.MigratedFromStateAnnotation:   &m.State,
+		},
+	})
+}
+
+// unlabelMigrated removes the migration labels from an app that has been
+// returned to its previous stack
+func (c *Changer) unlabelMigrated(appGUID, appName string) {
+	c.patchMetadata(appGUID, appName, resources.Metadata{
+		Labels: map[string]*string{
+			resources.MigratedFromLabel: nil,
+		},
+		Annotations: map[string]*string{
+			resources.MigratedAtAnnotation:          nil,
+			resources.MigratedByAnnotation:          nil,
+			resources.MigratedFromDropletAnnotation: nil,
+			resources.MigratedFromStateAnnotation:   nil,
+		},
+	})
+}
+
+// patchMetadata only warns on failure, since the stack change it describes
+// has already happened
+func (c *Changer) patchMetadata(appGUID, appName string, metadata resources.Metadata) {
+	body, err := json.Marshal(metadataRequest{Metadata: metadata})
+	if err == nil {
+		_, err = c.CF.Mutate(cf.ActionUpdateMetadata, appGUID, "/v3/apps/"+appGUID, "-X", "PATCH", "-d="+string(body))
+	}
+	if err != nil {
+		c.printf(ErrorRecordingMetadata, appName, err)
+	}
+}
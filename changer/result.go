@@ -0,0 +1,160 @@
+package changer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const JSONFlag = "json"
+
+// Failure categories reported in a Result
+const (
+	CategoryAppLookup       = "app_lookup"
+	CategoryUnsupportedApp  = "unsupported_app"
+	CategoryDisallowedPath  = "disallowed_path"
+	CategoryStackChanged    = "stack_changed"
+	CategoryInFlight        = "in_flight"
+	CategoryPreflight       = "preflight"
+	CategoryVetoed          = "vetoed"
+	CategoryOptedOut        = "opted_out"
+	CategoryRecording       = "recording"
+	CategoryStackAssignment = "stack_assignment"
+	CategoryRestage         = "restage"
+	CategoryProcessRestore  = "process_restore"
+	CategoryStateRestore    = "state_restore"
+	CategoryVerification    = "verification"
+	CategoryInterrupted     = "interrupted"
+	CategoryGated           = "gated"
+	CategoryUnknown         = "unknown"
+	PhaseVerification       = "verification"
+	PhaseRollback           = "rollback"
+)
+
+// Result describes the outcome of changing one app's stack
+type Result struct {
+	AppGUID         string           `json:"app_guid"`
+	AppName         string           `json:"app_name"`
+	Org             string           `json:"org"`
+	Space           string           `json:"space"`
+	OldStack        string           `json:"old_stack"`
+	NewStack        string           `json:"new_stack"`
+	OldDropletGUID  string           `json:"old_droplet_guid"`
+	NewDropletGUID  string           `json:"new_droplet_guid"`
+	RestoredState   string           `json:"restored_state"`
+	Drift           []string         `json:"drift,omitempty"`
+	DurationsMS     map[string]int64 `json:"durations_ms"`
+	Success         bool             `json:"success"`
+	RolledBack      bool             `json:"rolled_back"`
+	Unchanged       bool             `json:"unchanged"`
+	FailureCategory string           `json:"failure_category,omitempty"`
+	Hint            string           `json:"hint,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// StepError attributes a failure to one of the failure categories, with a
+// hint on how to fix it when one is known
+type StepError struct {
+	Category string
+	Hint     string
+	Err      error
+}
+
+func (e *StepError) Error() string {
+	if e.Hint != "" {
+		return e.Err.Error() + "\n" + fmt.Sprintf(HintMsg, e.Hint)
+	}
+	return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+func failure(category string, err error) error {
+	return &StepError{Category: category, Err: err}
+}
+
+// FailureCategory returns the category of the step that caused err
+func FailureCategory(err error) string {
+	var stepErr *StepError
+	if errors.As(err, &stepErr) {
+		return stepErr.Category
+	}
+	return CategoryUnknown
+}
+
+func newResult(m Migration) Result {
+	r := Result{DurationsMS: make(map[string]int64)}
+	r.update(m)
+	return r
+}
+
+func (r *Result) update(m Migration) {
+	r.AppGUID = m.AppGUID
+	r.AppName = m.AppName
+	r.Org = m.Org
+	r.Space = m.Space
+	r.OldStack = m.OldStack
+	r.NewStack = m.NewStack
+	r.OldDropletGUID = m.DropletGUID
+}
+
+func (r *Result) timed(phase string, start time.Time) {
+	r.DurationsMS[phase] = time.Since(start).Milliseconds()
+}
+
+func (r *Result) finish(err error) {
+	r.Success = err == nil
+	if err != nil {
+		r.FailureCategory = FailureCategory(err)
+		r.Error = err.Error()
+
+		var stepErr *StepError
+		if errors.As(err, &stepErr) {
+			r.Hint = stepErr.Hint
+		}
+	}
+}
+
+// report formats the outcome of a stack change for the configured output type
+func (c *Changer) report(result Result, err error) (string, error) {
+	if c.OutputType == JSONFlag {
+		out, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			return "", marshalErr
+		}
+		return string(out), err
+	}
+
+	if err != nil {
+		return "", err
+	}
+	return result.successMsg(), nil
+}
+
+func (r Result) successMsg() string {
+	if r.Unchanged {
+		return fmt.Sprintf(AlreadyOnStackMsg, r.AppName, r.NewStack)
+	}
+	return fmt.Sprintf(ChangeStackSuccessMsg, r.AppName, r.NewStack)
+}
+
+// printf writes progress messages, which go to stderr when stdout is
+// reserved for JSON output
+func (c *Changer) printf(format string, a ...interface{}) {
+	var w io.Writer = os.Stdout
+	if c.OutputType == JSONFlag {
+		w = os.Stderr
+	}
+
+	msg := fmt.Sprintf(format, a...)
+	if c.Log == nil {
+		fmt.Fprint(w, msg)
+		return
+	}
+	c.Log(w, msg)
+}
@@ -0,0 +1,112 @@
+package changer
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry/stack-auditor/resources"
+)
+
+const (
+	AttemptingToRevertStackMsg = "Attempting to revert the stack change of %s...\n\n"
+	NoProvenanceError          = "%s has no record of a stack change to revert; pass --journal <file> if it was changed with one"
+	NotInJournalError          = "journal %s has no stack change of %s in space %s"
+	NoDropletOnStackError      = "no staged droplet found for %s on stack %s"
+)
+
+// RevertStack returns an app to the stack, droplet and state it had before
+// change-stack migrated it. The migration is read from the journal when one
+// is given, or else from the annotations written on the app when it was
+// migrated. Apps migrated before the droplet was annotated return to their
+// newest droplet staged on the original stack.
+func (c *Changer) RevertStack(appName, journalPath string) (string, error) {
+	c.printf(AttemptingToRevertStackMsg, fmt.Sprintf("%s/%s/", c.CF.Space.Name, appName))
+
+	var m Migration
+	var err error
+	if journalPath != "" {
+		m, err = c.journaledMigration(journalPath, appName)
+	} else {
+		m, err = c.annotatedMigration(appName)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.revert(m); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(RevertSuccessMsg, appName, m.OldStack, m.DropletGUID), nil
+}
+
+// journaledMigration returns the latest migration of the app in the
+// targeted space recorded in the journal, if it changed the app's stack
+func (c *Changer) journaledMigration(path, appName string) (Migration, error) {
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.AppName != appName || entry.SpaceGUID != c.CF.Space.Guid {
+			continue
+		}
+		if entry.Step == StepReverted {
+			return Migration{}, fmt.Errorf(AlreadyRevertedMsg, appName, entry.OldStack, entry.DropletGUID)
+		}
+		if !entry.changed() || entry.AppGUID == "" {
+			return Migration{}, fmt.Errorf(NothingToRevertMsg, appName)
+		}
+		return entry.Migration, nil
+	}
+	return Migration{}, fmt.Errorf(NotInJournalError, path, appName, c.CF.Space.Name)
+}
+
+// annotatedMigration rebuilds the migration of the app from the label and
+// annotations written on it when it was migrated
+func (c *Changer) annotatedMigration(appName string) (Migration, error) {
+	app, err := c.CF.GetAppByName(appName)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	m := c.newMigration(appName, app.Lifecycle.Data.Stack)
+	m.AppGUID = app.GUID
+	m.OldStack = app.Metadata.Label(resources.MigratedFromLabel)
+	m.DropletGUID = app.Metadata.Annotation(resources.MigratedFromDropletAnnotation)
+	m.State = app.Metadata.Annotation(resources.MigratedFromStateAnnotation)
+
+	if m.OldStack == "" {
+		return Migration{}, fmt.Errorf(NoProvenanceError, appName)
+	}
+	if m.State == "" {
+		m.State = app.State
+	}
+	if m.DropletGUID != "" {
+		return m, nil
+	}
+
+	droplets, err := c.CF.GetStagedDroplets(app.GUID)
+	if err != nil {
+		return Migration{}, err
+	}
+	for _, droplet := range droplets {
+		if droplet.Stack == m.OldStack {
+			m.DropletGUID = droplet.GUID
+			return m, nil
+		}
+	}
+	return Migration{}, fmt.Errorf(NoDropletOnStackError, appName, m.OldStack)
+}
+
+// revert returns the app of a migration to its original stack, droplet and
+// state, and removes its migration labels. Its processes are left as they
+// are, since they may have been scaled on purpose after the migration.
+func (c *Changer) revert(m Migration) error {
+	if err := c.rollback(m.AppGUID, m.OldStack, m.DropletGUID, m.State); err != nil {
+		return err
+	}
+
+	c.unlabelMigrated(m.AppGUID, m.AppName)
+	c.record(m, StepReverted, nil)
+	return nil
+}
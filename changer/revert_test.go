@@ -0,0 +1,159 @@
+package changer_test
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/resources"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RevertStack", func() {
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.NewMockCliConnection(mockCtrl)
+		mockRunner = NewMockRunner(mockCtrl)
+
+		c = changer.Changer{
+			Runner: mockRunner,
+			CF: cf.CF{
+				Conn: mockConnection,
+				Space: plugin_models.Space{
+					SpaceFields: plugin_models.SpaceFields{
+						Guid: mocks.SpaceGuid,
+						Name: mocks.SpaceName,
+					},
+				},
+			},
+			Log: func(w io.Writer, msg string) {
+				logMsg = msg
+			},
+			LogDir: GinkgoT().TempDir(),
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	withApp := func(fixture string) {
+		app, err := mocks.FileToString(fixture)
+		Expect(err).ToNot(HaveOccurred())
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps?names="+AppAName+"&space_guids="+mocks.SpaceGuid).Return(app, nil).AnyTimes()
+	}
+
+	It("returns the app to the stack, droplet and state annotated when it was migrated", func() {
+		withApp("appAMigrated.json")
+		expectRollback(AppAGuid, StackBName, AppAPreviousDropletGuid)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		result, err := c.RevertStack(AppAName, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(fmt.Sprintf(changer.RevertSuccessMsg, AppAName, StackBName, AppAPreviousDropletGuid)))
+	})
+
+	It("uses the newest droplet staged on the original stack when the droplet was not annotated", func() {
+		withApp("appAMigratedLabelOnly.json")
+		expectRollback(AppAGuid, StackBName, AppAPreviousDropletGuid)
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		_, err := c.RevertStack(AppAName, "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("refuses an app without a record of its migration", func() {
+		mocks.ExpectDefaultResponses(mockConnection)
+
+		_, err := c.RevertStack(AppAName, "")
+		Expect(err).To(MatchError(fmt.Sprintf(changer.NoProvenanceError, AppAName)))
+	})
+
+	When("given a journal", func() {
+		var journalPath string
+
+		BeforeEach(func() {
+			journalPath = filepath.Join(GinkgoT().TempDir(), "journal.jsonl")
+			var err error
+			c.Journal, err = changer.OpenJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(c.Journal.Close)
+		})
+
+		It("returns the app to the stack, droplet and state recorded in the journal", func() {
+			Expect(c.Journal.Record(changer.Migration{
+				AppGUID:     AppAGuid,
+				AppName:     AppAName,
+				SpaceGUID:   mocks.SpaceGuid,
+				OldStack:    StackBName,
+				NewStack:    StackAName,
+				State:       "STARTED",
+				DropletGUID: AppAPreviousDropletGuid,
+			}, changer.StepCompleted, nil)).To(Succeed())
+			expectRollback(AppAGuid, StackBName, AppAPreviousDropletGuid)
+			mocks.ExpectDefaultResponses(mockConnection)
+
+			_, err := c.RevertStack(AppAName, journalPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := changer.ReadJournal(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries[0].Step).To(Equal(changer.StepReverted))
+		})
+
+		It("leaves processes scaled since the migration as they are", func() {
+			Expect(c.Journal.Record(changer.Migration{
+				AppGUID:     AppAGuid,
+				AppName:     AppAName,
+				SpaceGUID:   mocks.SpaceGuid,
+				OldStack:    StackBName,
+				NewStack:    StackAName,
+				State:       "STARTED",
+				DropletGUID: AppAPreviousDropletGuid,
+				Snapshot: &changer.AppSnapshot{
+					Processes: []resources.Process{
+						{Type: "web", Instances: 3, MemoryInMB: 256, DiskInMB: 1024, HealthCheck: resources.HealthCheck{Type: "port"}},
+					},
+				},
+			}, changer.StepCompleted, nil)).To(Succeed())
+			expectRollback(AppAGuid, StackBName, AppAPreviousDropletGuid)
+			mocks.ExpectDefaultResponses(mockConnection)
+
+			_, err := c.RevertStack(AppAName, journalPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("refuses an app the journal does not show as changed", func() {
+			Expect(c.Journal.Record(changer.Migration{AppName: AppAName, SpaceGUID: mocks.SpaceGuid, NewStack: StackBName}, changer.StepPlanned, nil)).To(Succeed())
+
+			_, err := c.RevertStack(AppAName, journalPath)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.NothingToRevertMsg, AppAName)))
+
+			_, err = c.RevertStack(AppBName, journalPath)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.NotInJournalError, journalPath, AppBName, mocks.SpaceName)))
+		})
+
+		It("refuses an app the journal shows was already on its new stack", func() {
+			Expect(c.Journal.Record(changer.Migration{
+				AppGUID:     AppAGuid,
+				AppName:     AppAName,
+				SpaceGUID:   mocks.SpaceGuid,
+				OldStack:    StackAName,
+				NewStack:    StackAName,
+				State:       "STARTED",
+				DropletGUID: AppADropletGuid,
+			}, changer.StepUnchanged, nil)).To(Succeed())
+
+			_, err := c.RevertStack(AppAName, journalPath)
+			Expect(err).To(MatchError(fmt.Sprintf(changer.NothingToRevertMsg, AppAName)))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package changer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StagingLogTailLines   = 20
+	StagingLogMsg         = "staging output saved to %s"
+	ErrorSavingStagingLog = "staging output could not be saved: %v"
+)
+
+// StagingError is a failed restage together with the output it produced
+type StagingError struct {
+	Output  string
+	LogPath string
+	Err     error
+}
+
+func (e *StagingError) Error() string {
+	msg := e.Err.Error()
+	if e.LogPath != "" {
+		msg += "; " + fmt.Sprintf(StagingLogMsg, e.LogPath)
+	}
+	if tail := tailLines(e.Output, StagingLogTailLines); tail != "" {
+		msg += "\n" + tail
+	}
+	return msg
+}
+
+func (e *StagingError) Unwrap() error {
+	return e.Err
+}
+
+// saveStagingLog writes the output of a failed restage to a file named after
+// the app in the log directory, falling back to the system temp directory
+func (c *Changer) saveStagingLog(m *Migration, output string) (string, error) {
+	dir := c.LogDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	name := fmt.Sprintf("%s-%s-%s-staging.log", m.Space, m.AppName, time.Now().UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(output+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func tailLines(output string, n int) string {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return ""
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return "  " + strings.Join(lines, "\n  ")
+}
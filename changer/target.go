@@ -0,0 +1,118 @@
+package changer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/planner"
+)
+
+const ErrorTargetingSpace = "problem targeting space %s/%s"
+
+// restage restages the app with the cf CLI. When the app lives outside the
+// space targeted by the CLI, the restage runs against a private copy of the
+// CLI config targeted at the app's space, passed in its own environment, so
+// that the user's own target is left untouched and apps in other spaces
+// restage at the same time. Apps are restaged with the rolling strategy unless the
+// restart strategy was chosen, which stops the app while it restages. A
+// failed restage returns a StagingError holding the output, which is also
+// saved to a log file.
+func (c *Changer) restage(m *Migration) error {
+	env, restore, err := c.targetAppSpace()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	args := []string{"restage", "--strategy", planner.StrategyRolling, m.AppName}
+	if c.Strategy == planner.StrategyRestart {
+		args = []string{"restage", m.AppName}
+	}
+
+	var output string
+	if env == nil {
+		output, err = c.Runner.RunWithOutput("cf", ".", true, args...)
+	} else {
+		output, err = c.Runner.RunWithOutputEnv("cf", ".", true, env, args...)
+	}
+	c.CF.Audit(cf.ActionRestage, "cf "+strings.Join(args, " "), m.AppGUID, err)
+	if err == nil {
+		return nil
+	}
+
+	stagingErr := &StagingError{Output: output, Err: err}
+	stagingErr.LogPath, err = c.saveStagingLog(m, output)
+	if err != nil {
+		c.printf(ErrorSavingStagingLog+"\n", err)
+	}
+	return stagingErr
+}
+
+// targetAppSpace returns the environment the cf CLI needs to act on the
+// app's space, and the function that cleans it up. An app in the space
+// targeted by the CLI needs none; for any other app it sets CF_HOME to a copy
+// of the CLI config targeted at the app's space.
+func (c *Changer) targetAppSpace() ([]string, func(), error) {
+	current, err := c.CF.GetCurrentSpace()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if current.Guid == c.CF.Space.Guid {
+		return nil, func() {}, nil
+	}
+
+	cfHome, err := isolatedCFHome()
+	if err != nil {
+		return nil, nil, fmt.Errorf(ErrorTargetingSpace+": %w", c.CF.Org.Name, c.CF.Space.Name, err)
+	}
+
+	restore := func() {
+		os.RemoveAll(cfHome)
+	}
+
+	env := []string{"CF_HOME=" + cfHome}
+	if err := c.Runner.RunWithEnv("cf", ".", true, env, "target", "-o", c.CF.Org.Name, "-s", c.CF.Space.Name); err != nil {
+		restore()
+		return nil, nil, fmt.Errorf(ErrorTargetingSpace+": %w", c.CF.Org.Name, c.CF.Space.Name, err)
+	}
+
+	return env, restore, nil
+}
+
+// isolatedCFHome copies the CLI config into a temporary CF_HOME
+func isolatedCFHome() (string, error) {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	config, err := os.ReadFile(filepath.Join(home, ".cf", "config.json"))
+	if err != nil {
+		return "", err
+	}
+
+	cfHome, err := os.MkdirTemp("", "stack-auditor-cf-home")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Join(cfHome, ".cf"), 0700); err != nil {
+		os.RemoveAll(cfHome)
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(cfHome, ".cf", "config.json"), config, 0600); err != nil {
+		os.RemoveAll(cfHome)
+		return "", err
+	}
+
+	return cfHome, nil
+}
@@ -0,0 +1,133 @@
+package changer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	VerifyingAppMsg         = "Verifying application health for %s"
+	ErrorVerifyingApp       = "application failed verification on %s"
+	InstanceCrashedError    = "instance %d of process %s is %s"
+	InstanceNotRunning      = "instance %d of process %s is %s after verification window"
+	ProbeStatusError        = "probe of %s returned status %d, expected %d"
+	ProbeWithoutVerifyError = "--probe-url was given but no --verify-duration"
+	DefaultVerifyInterval   = 5 * time.Second
+	DefaultProbeStatusCode  = http.StatusOK
+)
+
+// Verification configures the health checks performed after an app has been
+// restaged on its new stack. A zero Duration disables verification.
+type Verification struct {
+	Duration    time.Duration
+	Interval    time.Duration
+	ProbeURL    string
+	ProbeStatus int
+}
+
+func (v Verification) Enabled() bool {
+	return v.Duration > 0
+}
+
+// Validate refuses a probe URL that would never be requested, as plans do
+func (v Verification) Validate() error {
+	if v.ProbeURL != "" && !v.Enabled() {
+		return errors.New(ProbeWithoutVerifyError)
+	}
+	return nil
+}
+
+// verify polls the instance stats of every process of the app, and
+// optionally probes a URL, until the verification window has passed. It
+// fails as soon as an instance crashes or the probe returns an unexpected
+// status, and at the end of the window if any instance is not running.
+func (c *Changer) verify(appGUID string) error {
+	c.printf(VerifyingAppMsg+"\n", c.Verification.Duration)
+
+	interval := c.Verification.Interval
+	if interval <= 0 {
+		interval = DefaultVerifyInterval
+	}
+
+	deadline := time.Now().Add(c.Verification.Duration)
+	for {
+		final := !time.Now().Before(deadline)
+
+		if err := c.checkInstances(appGUID, final); err != nil {
+			return err
+		}
+
+		if err := c.probe(); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+
+		if c.Interrupts.Received() {
+			c.printf(VerifyInterruptedMsg + "\n")
+			return nil
+		}
+
+		time.Sleep(min(interval, time.Until(deadline)))
+	}
+}
+
+func (c *Changer) checkInstances(appGUID string, requireRunning bool) error {
+	processes, err := c.CF.GetAppProcesses(appGUID)
+	if err != nil {
+		return err
+	}
+
+	for _, process := range processes {
+		if process.Instances == 0 {
+			continue
+		}
+
+		stats, err := c.CF.GetProcessStats(process.GUID)
+		if err != nil {
+			return err
+		}
+
+		for _, instance := range stats {
+			switch instance.State {
+			case "RUNNING":
+			case "CRASHED":
+				return fmt.Errorf(InstanceCrashedError, instance.Index, process.Type, instance.State)
+			default:
+				if requireRunning {
+					return fmt.Errorf(InstanceNotRunning, instance.Index, process.Type, instance.State)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Changer) probe() error {
+	if c.Verification.ProbeURL == "" {
+		return nil
+	}
+
+	expected := c.Verification.ProbeStatus
+	if expected == 0 {
+		expected = DefaultProbeStatusCode
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(c.Verification.ProbeURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return fmt.Errorf(ProbeStatusError, c.Verification.ProbeURL, resp.StatusCode, expected)
+	}
+
+	return nil
+}
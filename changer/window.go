@@ -0,0 +1,154 @@
+package changer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	InvalidWindowError   = "invalid maintenance window %q: expected [<day>[,<day>...]] <HH:MM>-<HH:MM>, e.g. \"Sat 02:00-06:00\""
+	InvalidTimezoneError = "invalid timezone %q: %v"
+	WaitingForWindowMsg  = "Outside maintenance window %s; pausing until %s\n"
+	WindowOpenedMsg      = "Maintenance window %s is open; continuing\n"
+	windowPollInterval   = time.Second
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring period in which apps may be restarted. It starts on
+// each of Days (every day when empty) and may run past midnight.
+type Window struct {
+	Spec     string
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseWindow parses a window such as "Sat 02:00-06:00" or "Mon,Wed
+// 22:00-01:00" in the named timezone, or the local timezone if none is given
+func ParseWindow(spec, timezone string) (*Window, error) {
+	w := &Window{Spec: spec, Location: time.Local}
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf(InvalidTimezoneError, timezone, err)
+		}
+		w.Location = location
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf(InvalidWindowError, spec)
+	}
+
+	if len(fields) == 2 {
+		for _, name := range strings.Split(fields[0], ",") {
+			day, ok := weekdays[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf(InvalidWindowError, spec)
+			}
+			w.Days = append(w.Days, day)
+		}
+	}
+
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf(InvalidWindowError, spec)
+	}
+
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return nil, fmt.Errorf(InvalidWindowError, spec)
+	}
+	if w.End, err = parseClock(end); err != nil || w.End == w.Start {
+		return nil, fmt.Errorf(InvalidWindowError, spec)
+	}
+
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.Location)
+	// a window running past midnight may have opened the previous day
+	for _, day := range []time.Time{t, t.AddDate(0, 0, -1)} {
+		start := w.opening(day)
+		if w.startsOn(start.Weekday()) && !t.Before(start) && t.Before(w.closing(start)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the next time at or after t that the window opens
+func (w *Window) Next(t time.Time) time.Time {
+	t = t.In(w.Location)
+	for i := 0; i <= 7; i++ {
+		start := w.opening(t.AddDate(0, 0, i))
+		if w.startsOn(start.Weekday()) && !start.Before(t) {
+			return start
+		}
+	}
+	return t
+}
+
+func (w *Window) opening(day time.Time) time.Time {
+	hour, minute := int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, w.Location)
+}
+
+func (w *Window) closing(start time.Time) time.Time {
+	length := w.End - w.Start
+	if length < 0 {
+		length += 24 * time.Hour
+	}
+	return start.Add(length)
+}
+
+func (w *Window) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitWindow blocks until the maintenance window is open or the run is
+// interrupted. Completed steps are already in the journal, so a run that is
+// stopped while waiting can be resumed later.
+func (c *Changer) awaitWindow() {
+	if c.Window == nil || c.Window.Contains(time.Now()) {
+		return
+	}
+
+	c.printf(WaitingForWindowMsg, c.Window.Spec, c.Window.Next(time.Now()).Format(time.RFC1123))
+	for !c.Interrupts.Received() {
+		if c.Window.Contains(time.Now()) {
+			c.printf(WindowOpenedMsg, c.Window.Spec)
+			return
+		}
+		time.Sleep(windowPollInterval)
+	}
+}
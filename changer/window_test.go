@@ -0,0 +1,89 @@
+package changer_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/changer"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Window", func() {
+	var berlin *time.Location
+
+	BeforeEach(func() {
+		var err error
+		berlin, err = time.LoadLocation("Europe/Berlin")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	at := func(day, clock string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", day+" "+clock, berlin)
+		Expect(err).ToNot(HaveOccurred())
+		return t
+	}
+
+	It("contains times inside the window on its day in its timezone", func() {
+		w, err := changer.ParseWindow("Sat 02:00-06:00", "Europe/Berlin")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Contains(at("2026-10-24", "02:00"))).To(BeTrue())
+		Expect(w.Contains(at("2026-10-24", "05:59"))).To(BeTrue())
+		Expect(w.Contains(at("2026-10-24", "06:00"))).To(BeFalse())
+		Expect(w.Contains(at("2026-10-23", "03:00"))).To(BeFalse())
+		Expect(w.Contains(at("2026-10-24", "03:00").UTC())).To(BeTrue())
+	})
+
+	It("finds the next opening", func() {
+		w, err := changer.ParseWindow("Sat 02:00-06:00", "Europe/Berlin")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Next(at("2026-10-19", "12:00"))).To(BeTemporally("==", at("2026-10-24", "02:00")))
+		Expect(w.Next(at("2026-10-24", "07:00"))).To(BeTemporally("==", at("2026-10-31", "02:00")))
+	})
+
+	It("supports windows that run past midnight on several days", func() {
+		w, err := changer.ParseWindow("fri,sat 22:00-01:00", "Europe/Berlin")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Contains(at("2026-10-24", "00:30"))).To(BeTrue())
+		Expect(w.Contains(at("2026-10-25", "00:30"))).To(BeTrue())
+		Expect(w.Contains(at("2026-10-26", "00:30"))).To(BeFalse())
+	})
+
+	It("rejects malformed windows and timezones", func() {
+		_, err := changer.ParseWindow("Someday 02:00-06:00", "")
+		Expect(err).To(MatchError(fmt.Sprintf(changer.InvalidWindowError, "Someday 02:00-06:00")))
+
+		_, err = changer.ParseWindow("Sat 02:00", "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = changer.ParseWindow("Sat 02:00-06:00", "Mars/Olympus")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not start bulk migrations outside the window", func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.SetupMockCliConnection(mockCtrl)
+		c = changer.Changer{
+			Runner:     NewMockRunner(mockCtrl),
+			Interrupts: &changer.Interrupts{},
+		}
+		c.CF.Conn = mockConnection
+		DeferCleanup(mockCtrl.Finish)
+
+		now := time.Now().UTC()
+		spec := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+		var err error
+		c.Window, err = changer.ParseWindow(spec, "UTC")
+		Expect(err).ToNot(HaveOccurred())
+
+		c.Interrupts.Trigger()
+		_, err = c.ChangeStacks([]string{AppAName, AppBName}, StackBName)
+		Expect(err).To(MatchError(fmt.Sprintf(changer.InterruptedRunError, 2)))
+	})
+})
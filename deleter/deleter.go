@@ -32,7 +32,7 @@ func (d *Deleter) DeleteStack(stackName string) (string, error) {
 		return "", err
 	}
 
-	lines, err := d.CF.CFCurl("/v2/stacks/"+stackGuid, "-X", "DELETE")
+	lines, err := d.CF.Mutate(cf.ActionDeleteStack, stackGuid, "/v2/stacks/"+stackGuid, "-X", "DELETE")
 	if err != nil {
 		return "", err
 	}
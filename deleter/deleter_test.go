@@ -1,7 +1,10 @@
 package deleter_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/cloudfoundry/stack-auditor/cf"
 
@@ -49,6 +52,28 @@ var _ = Describe("Deleter", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(result).To(ContainSubstring(fmt.Sprintf("Stack %s has been deleted", StackEName)))
 		})
+
+		It("records the deletion in the audit log", func() {
+			auditPath := filepath.Join(GinkgoT().TempDir(), "audit.log")
+			var err error
+			d.CF.AuditLog, err = cf.OpenAuditLog(auditPath, "some-user", "https://api.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			defer d.CF.AuditLog.Close()
+
+			mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v2/stacks/"+StackEGuid, "-X", "DELETE").Return([]string{}, nil)
+			_, err = d.DeleteStack(StackEName)
+			Expect(err).ToNot(HaveOccurred())
+
+			contents, err := os.ReadFile(auditPath)
+			Expect(err).NotTo(HaveOccurred())
+			var entry cf.AuditEntry
+			Expect(json.Unmarshal(contents, &entry)).To(Succeed())
+			Expect(entry.User).To(Equal("some-user"))
+			Expect(entry.Endpoint).To(Equal("DELETE /v2/stacks/" + StackEGuid))
+			Expect(entry.TargetGUID).To(Equal(StackEGuid))
+			Expect(entry.Action).To(Equal(cf.ActionDeleteStack))
+			Expect(entry.Result).To(Equal(cf.AuditSuccess))
+		})
 	})
 
 	When("deleting a stack that does not exist", func() {
@@ -118,6 +118,35 @@ var _ = Describe("Integration", Label("integration"), func() {
 		})
 	})
 
+	When("Rollback Stack", func() {
+		It("returns the app to its droplet on the previous stack", func() {
+			app := cutlass.New(filepath.Join("testdata", "simple_app"))
+			app.Buildpacks = []string{"https://github.com/cloudfoundry/ruby-buildpack#v1.9.4"}
+			app.Stack = oldStack
+
+			PushAppAndConfirm(app, true)
+			defer app.Destroy()
+
+			cmd := exec.Command("cf", "change-stack", app.Name, newStack)
+			out, err := cmd.CombinedOutput()
+			Expect(err).ToNot(HaveOccurred(), string(out))
+
+			breaker := make(chan bool)
+			go confirmZeroDowntime(app, breaker)
+
+			cmd = exec.Command("cf", "rollback-stack", app.Name)
+			out, err = cmd.CombinedOutput()
+			Expect(err).ToNot(HaveOccurred(), string(out))
+			Expect(string(out)).To(ContainSubstring("successfully rolled back to Stack " + oldStack))
+			close(breaker)
+
+			cmd = exec.Command("cf", "app", app.Name)
+			contents, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(oldStack))
+		})
+	})
+
 	When("Audit Stack", func() {
 		// 2 apps forces pagination if integration test binary is built which forces per page to 1. See ./script/build.sh
 		const appCount = 2
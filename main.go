@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/cloudfoundry/stack-auditor/auditor"
 	"github.com/cloudfoundry/stack-auditor/cf"
 	"github.com/cloudfoundry/stack-auditor/changer"
 	"github.com/cloudfoundry/stack-auditor/deleter"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/cloudfoundry/stack-auditor/resources"
 	"github.com/cloudfoundry/stack-auditor/terminalUI"
 	"github.com/cloudfoundry/stack-auditor/utils"
 
@@ -45,8 +51,16 @@ const (
 	AuditStackCmd      = "audit-stack"
 	ChangeStackCmd     = "change-stack"
 	DeleteStackCmd     = "delete-stack"
-	ChangeStackUsage   = "Usage: cf change-stack <app> <stack>"
+	RollbackStackCmd   = "rollback-stack"
+	RevertStackCmd     = "revert-stack"
+	StackPlanCmd       = "stack-plan"
+	StackApplyCmd      = "stack-apply"
+	ChangeStackUsage   = "Usage: cf change-stack (<app>... [--org <org>] [--space <space>] | --guid <app-guid> | --from-file <file>) <stack> [--canary <n>|<n>%] [--max-failure-rate <percent>] [--parallel <n> [--max-per-org <n>] [--max-per-space <n>]] [--policy <file>] [--wait-in-flight <duration>] [--estimate] [--verify-duration <duration>] [--probe-url <url>] [--probe-status <code>] [--journal <file>] [--pre-hook <exe>] [--post-hook <exe>] [--window <window> [--timezone <tz>]] [--log-dir <dir>] [--json]\n       cf change-stack (--resume <file> [--pre-hook <exe>] [--post-hook <exe>] [--window <window> [--timezone <tz>]] | --revert <file>) [--log-dir <dir>] [--json]"
+	RollbackStackUsage = "Usage: cf rollback-stack (<app> [--org <org>] [--space <space>] | --guid <app-guid>)"
+	RevertStackUsage   = "Usage: cf revert-stack (<app> [--org <org>] [--space <space>] | --guid <app-guid>) [--journal <file>]"
 	AuditStackUsage    = "Usage: cf audit-stack [--json | --csv]"
+	StackPlanUsage     = "Usage: cf stack-plan --from <stack> --to <stack> [--strategy rolling|restart] [--verify-duration <duration>] [--probe-url <url>] [--probe-status <code>] > plan.yml"
+	StackApplyUsage    = "Usage: cf stack-apply <plan.yml> [--max-failure-rate <percent>] [--parallel <n> [--max-per-org <n>] [--max-per-space <n>]] [--policy <file>] [--wait-in-flight <duration>] [--estimate] [--journal <file>] [--pre-hook <exe>] [--post-hook <exe>] [--window <window> [--timezone <tz>]] [--log-dir <dir>] [--json]"
 	ErrorMsg           = "a problem occurred: %v\n"
 	IncorrectArguments = "Incorrect arguments provided - %s\n"
 )
@@ -104,9 +118,16 @@ func (s *StackAuditor) Run(cliConnection plugin.CliConnection, args []string) {
 			os.Exit(1)
 		}
 
+		auditLog, err := openAuditLog(cliConnection)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		defer auditLog.Close()
+
 		a := deleter.Deleter{
 			CF: cf.CF{
-				Conn: cliConnection,
+				Conn:     cliConnection,
+				AuditLog: auditLog,
 			},
 		}
 		info, err := a.DeleteStack(args[1])
@@ -116,14 +137,281 @@ func (s *StackAuditor) Run(cliConnection plugin.CliConnection, args []string) {
 		fmt.Println(info)
 
 	case ChangeStackCmd:
-		if len(args) != 3 && len(args) != 4 {
-			log.Fatalf("Incorrect arguments provided - %s\n", ChangeStackUsage)
+		c := changer.Changer{
+			Log: func(w io.Writer, msg string) {
+				w.Write([]byte(msg))
+			},
+			Version: tagVersion,
+		}
+
+		var target appTarget
+		flags := flag.NewFlagSet(ChangeStackCmd, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		target.register(flags)
+		flags.DurationVar(&c.Verification.Duration, "verify-duration", 0, "")
+		flags.StringVar(&c.Verification.ProbeURL, "probe-url", "", "")
+		flags.IntVar(&c.Verification.ProbeStatus, "probe-status", changer.DefaultProbeStatusCode, "")
+		var run runFlags
+		run.register(flags, &c)
+		run.registerCanary()
+		journalPath := flags.String("journal", "", "")
+		resumePath := flags.String("resume", "", "")
+		revertPath := flags.String("revert", "", "")
+		fromFile := flags.String("from-file", "", "")
+
+		positional, err := parseArgs(flags, args[1:])
+		if err != nil {
+			log.Fatalf(IncorrectArguments, ChangeStackUsage)
+		}
+
+		c.Runner = utils.Command{}
+
+		c.CF = cf.CF{
+			Conn: cliConnection,
+		}
+		c.CF.AuditLog, err = openAuditLog(cliConnection)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		defer c.CF.AuditLog.Close()
+
+		journaled := *resumePath != "" || *revertPath != ""
+		if journaled && (len(positional) != 0 || *journalPath != "" || (*resumePath != "" && *revertPath != "")) {
+			log.Fatalf(IncorrectArguments, ChangeStackUsage)
+		}
+		if *fromFile != "" && (journaled || len(positional) != 1 || target != (appTarget{})) {
+			log.Fatalf(IncorrectArguments, ChangeStackUsage)
+		}
+		if !journaled && *fromFile == "" && (len(positional) < target.argCount()+1 || (target.guid != "" && len(positional) != 1)) {
+			log.Fatalf(IncorrectArguments, ChangeStackUsage)
+		}
+		if c.Estimate && (journaled || *journalPath != "") {
+			log.Fatalf(IncorrectArguments, ChangeStackUsage)
+		}
+
+		if err := c.Verification.Validate(); err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		if err := run.apply(&c); err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		if *fromFile != "" || len(positional) > 2 {
+			if err := run.requireJournal(&c, *journalPath); err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+		}
+
+		if path := *journalPath + *resumePath + *revertPath; path != "" {
+			c.Journal, err = changer.OpenJournal(path)
+			if err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+			defer c.Journal.Close()
+		}
+
+		c.Confirm = s.confirmContinue
+		c.Interrupts = changer.NotifyInterrupts()
+		defer c.Interrupts.Stop()
+		c.CF.Conn = utils.FallbackConnection{CliConnection: cliConnection, Detached: c.Interrupts.Received}
+
+		var info string
+		switch {
+		case *resumePath != "":
+			info, err = c.ResumeJournal(*resumePath)
+		case *revertPath != "":
+			info, err = c.RevertJournal(*revertPath)
+		case *fromFile != "":
+			var apps resources.Apps
+			apps, err = readAppList(*fromFile)
+			if err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+			info, err = c.ChangeListedStacks(apps, positional[0])
+		default:
+			var appName string
+			appName, err = target.resolve(&c.CF, positional)
+			if err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+
+			newStack := positional[len(positional)-1]
+			switch {
+			case len(positional) > 2:
+				info, err = c.ChangeStacks(positional[:len(positional)-1], newStack)
+			case c.Estimate:
+				info, err = c.ChangeStacks([]string{appName}, newStack)
+			default:
+				info, err = c.ChangeStack(appName, newStack)
+			}
+		}
+
+		if info != "" {
+			fmt.Println(info)
+		}
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+	case StackPlanCmd:
+		var defaults planner.Wave
+		var verifyDuration time.Duration
+		flags := flag.NewFlagSet(StackPlanCmd, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		from := flags.String("from", "", "")
+		to := flags.String("to", "", "")
+		flags.StringVar(&defaults.Strategy, "strategy", planner.StrategyRolling, "")
+		flags.DurationVar(&verifyDuration, "verify-duration", 0, "")
+		flags.StringVar(&defaults.ProbeURL, "probe-url", "", "")
+		flags.IntVar(&defaults.ProbeStatus, "probe-status", changer.DefaultProbeStatusCode, "")
+
+		positional, err := parseArgs(flags, args[1:])
+		if err != nil || len(positional) != 0 || *from == "" || *to == "" {
+			log.Fatalf(IncorrectArguments, StackPlanUsage)
+		}
+		if defaults.Strategy != planner.StrategyRolling && defaults.Strategy != planner.StrategyRestart {
+			log.Fatalf(IncorrectArguments, StackPlanUsage)
+		}
+		if verifyDuration > 0 {
+			defaults.VerifyDuration = verifyDuration.String()
+		}
+		if defaults.ProbeURL == "" {
+			defaults.ProbeStatus = 0
+		}
+
+		p := planner.Planner{
+			CF: cf.CF{
+				Conn: cliConnection,
+			},
+		}
+		plan, err := p.Plan(*from, *to, defaults)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+		info, err := plan.YAML()
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		fmt.Print(info)
+
+	case StackApplyCmd:
+		c := changer.Changer{
+			Log: func(w io.Writer, msg string) {
+				w.Write([]byte(msg))
+			},
+			Version: tagVersion,
+		}
+
+		flags := flag.NewFlagSet(StackApplyCmd, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		var run runFlags
+		run.register(flags, &c)
+		journalPath := flags.String("journal", "", "")
+
+		positional, err := parseArgs(flags, args[1:])
+		if err != nil || len(positional) != 1 || (c.Estimate && *journalPath != "") {
+			log.Fatalf(IncorrectArguments, StackApplyUsage)
+		}
+		if err := run.apply(&c); err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		if err := run.requireJournal(&c, *journalPath); err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+		plan, err := planner.ReadPlan(positional[0])
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+		c.Runner = utils.Command{}
+
+		c.CF = cf.CF{
+			Conn: cliConnection,
+		}
+		c.CF.AuditLog, err = openAuditLog(cliConnection)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		defer c.CF.AuditLog.Close()
+
+		if *journalPath != "" {
+			c.Journal, err = changer.OpenJournal(*journalPath)
+			if err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+			defer c.Journal.Close()
+		}
+
+		c.Confirm = s.confirmContinue
+		c.Interrupts = changer.NotifyInterrupts()
+		defer c.Interrupts.Stop()
+		c.CF.Conn = utils.FallbackConnection{CliConnection: cliConnection, Detached: c.Interrupts.Received}
+
+		info, err := c.ApplyPlan(plan)
+		if info != "" {
+			fmt.Println(info)
+		}
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+	case RollbackStackCmd:
+		c := changer.Changer{
+			Log: func(w io.Writer, msg string) {
+				w.Write([]byte(msg))
+			},
+			Version: tagVersion,
+		}
+
+		var target appTarget
+		flags := flag.NewFlagSet(RollbackStackCmd, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		target.register(flags)
+
+		positional, err := parseArgs(flags, args[1:])
+		if err != nil || len(positional) != target.argCount() {
+			log.Fatalf(IncorrectArguments, RollbackStackUsage)
+		}
+
+		c.Runner = utils.Command{}
+
+		c.CF = cf.CF{
+			Conn: cliConnection,
+		}
+		c.CF.AuditLog, err = openAuditLog(cliConnection)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		defer c.CF.AuditLog.Close()
+		appName, err := target.resolve(&c.CF, positional)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+
+		info, err := c.RollbackStack(appName)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
 		}
+		fmt.Println(info)
 
+	case RevertStackCmd:
 		c := changer.Changer{
 			Log: func(w io.Writer, msg string) {
 				w.Write([]byte(msg))
 			},
+			Version: tagVersion,
+		}
+
+		var target appTarget
+		flags := flag.NewFlagSet(RevertStackCmd, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		target.register(flags)
+		journalPath := flags.String("journal", "", "")
+
+		positional, err := parseArgs(flags, args[1:])
+		if err != nil || len(positional) != target.argCount() {
+			log.Fatalf(IncorrectArguments, RevertStackUsage)
 		}
 
 		c.Runner = utils.Command{}
@@ -131,13 +419,25 @@ func (s *StackAuditor) Run(cliConnection plugin.CliConnection, args []string) {
 		c.CF = cf.CF{
 			Conn: cliConnection,
 		}
-		space, err := c.CF.Conn.GetCurrentSpace()
+		c.CF.AuditLog, err = openAuditLog(cliConnection)
+		if err != nil {
+			log.Fatalf(ErrorMsg, err)
+		}
+		defer c.CF.AuditLog.Close()
+		appName, err := target.resolve(&c.CF, positional)
 		if err != nil {
 			log.Fatalf(ErrorMsg, err)
 		}
-		c.CF.Space = space
 
-		info, err := c.ChangeStack(args[1], args[2])
+		if *journalPath != "" {
+			c.Journal, err = changer.OpenJournal(*journalPath)
+			if err != nil {
+				log.Fatalf(ErrorMsg, err)
+			}
+			defer c.Journal.Close()
+		}
+
+		info, err := c.RevertStack(appName, *journalPath)
 		if err != nil {
 			log.Fatalf(ErrorMsg, err)
 		}
@@ -151,6 +451,219 @@ func (s *StackAuditor) Run(cliConnection plugin.CliConnection, args []string) {
 	}
 }
 
+// runFlags holds the flags that control how a run of stack changes
+// proceeds, shared by the commands that change stacks
+type runFlags struct {
+	flags          *flag.FlagSet
+	window         string
+	timezone       string
+	canary         string
+	maxFailureRate float64
+	parallel       int
+	maxPerOrg      int
+	maxPerSpace    int
+	policy         string
+	jsonOutput     bool
+}
+
+func (r *runFlags) register(flags *flag.FlagSet, c *changer.Changer) {
+	r.flags = flags
+	flags.StringVar(&c.LogDir, "log-dir", "", "")
+	flags.StringVar(&c.Hooks.Pre, "pre-hook", "", "")
+	flags.StringVar(&c.Hooks.Post, "post-hook", "", "")
+	flags.StringVar(&r.window, "window", "", "")
+	flags.StringVar(&r.timezone, "timezone", "", "")
+	flags.Float64Var(&r.maxFailureRate, "max-failure-rate", 0, "")
+	flags.IntVar(&r.parallel, "parallel", 1, "")
+	flags.IntVar(&r.maxPerOrg, "max-per-org", 0, "")
+	flags.IntVar(&r.maxPerSpace, "max-per-space", 0, "")
+	flags.StringVar(&r.policy, "policy", "", "")
+	flags.DurationVar(&c.InFlight.Wait, "wait-in-flight", 0, "")
+	flags.BoolVar(&c.Estimate, "estimate", false, "")
+	flags.BoolVar(&r.jsonOutput, "json", false, "")
+}
+
+// registerCanary adds the flag that splits a bulk run into canary waves
+func (r *runFlags) registerCanary() {
+	r.flags.StringVar(&r.canary, "canary", "", "")
+}
+
+// requireJournal refuses a bulk run with a maintenance window but no
+// journal, since the progress of a run stopped while paused outside the
+// window would otherwise be lost
+func (r runFlags) requireJournal(c *changer.Changer, journalPath string) error {
+	if r.window != "" && !c.Estimate && journalPath == "" {
+		return errors.New("--window requires --journal when changing more than one app, so a paused run can be resumed")
+	}
+	return nil
+}
+
+// apply sets the output type, maintenance window, concurrency limits,
+// migration policy and failure-rate gate of c
+func (r runFlags) apply(c *changer.Changer) error {
+	if r.jsonOutput {
+		c.OutputType = changer.JSONFlag
+	}
+
+	if r.timezone != "" && r.window == "" {
+		return errors.New("--timezone is only valid with --window")
+	}
+
+	var err error
+	c.Concurrency, err = changer.NewConcurrency(r.parallel, r.maxPerOrg, r.maxPerSpace)
+	if err != nil {
+		return err
+	}
+
+	if r.window != "" {
+		c.Window, err = changer.ParseWindow(r.window, r.timezone)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.policy != "" {
+		c.Policy, err = changer.ReadPolicy(r.policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	gated := r.canary != ""
+	r.flags.Visit(func(f *flag.Flag) {
+		gated = gated || f.Name == "max-failure-rate"
+	})
+	if gated {
+		c.Canary, err = changer.ParseCanary(r.canary, r.maxFailureRate)
+	}
+	return err
+}
+
+// openAuditLog opens the audit log that records every change made to the
+// foundation, and by whom
+func openAuditLog(conn plugin.CliConnection) (*cf.AuditLog, error) {
+	path, err := cf.DefaultAuditLogPath()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := conn.Username()
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := conn.ApiEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	return cf.OpenAuditLog(path, user, api)
+}
+
+// confirmContinue prompts on stderr so that json output on stdout stays
+// parseable
+func (s *StackAuditor) confirmContinue(prompt string) bool {
+	ui := terminalUI.UIController{
+		Scanner:      s.UI.Scanner,
+		OutputWriter: bufio.NewWriter(os.Stderr),
+	}
+	return ui.ConfirmContinue(prompt)
+}
+
+// appTarget holds the flags that select the app a command acts on when it is
+// not in the space targeted by the CLI
+type appTarget struct {
+	org   string
+	space string
+	guid  string
+}
+
+func (t *appTarget) register(flags *flag.FlagSet) {
+	flags.StringVar(&t.org, "org", "", "")
+	flags.StringVar(&t.space, "space", "", "")
+	flags.StringVar(&t.guid, "guid", "", "")
+}
+
+// argCount is the number of positional arguments used to name the app
+func (t appTarget) argCount() int {
+	if t.guid != "" {
+		return 0
+	}
+	return 1
+}
+
+// resolve points c at the app's space and returns the app's name
+func (t appTarget) resolve(c *cf.CF, positional []string) (string, error) {
+	if t.guid != "" {
+		if t.org != "" || t.space != "" {
+			return "", errors.New("--guid cannot be combined with --org or --space")
+		}
+
+		app, err := c.GetAppByGUID(t.guid)
+		if err != nil {
+			return "", err
+		}
+		return app.Name, c.TargetSpaceByGUID(app.Relationships.Space.Data.GUID)
+	}
+
+	if t.org == "" && t.space == "" {
+		return positional[0], c.TargetCurrentSpace()
+	}
+
+	if t.space == "" {
+		return "", errors.New("--space is required when --org is given")
+	}
+
+	orgName := t.org
+	if orgName == "" {
+		org, err := c.Conn.GetCurrentOrg()
+		if err != nil {
+			return "", err
+		}
+		orgName = org.Name
+	}
+
+	return positional[0], c.TargetSpace(orgName, t.space)
+}
+
+// readAppList reads the apps listed in a file, or on stdin when the path is -
+func readAppList(path string) (resources.Apps, error) {
+	if path == "-" {
+		return resources.ReadApps(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	apps, err := resources.ReadApps(file)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading apps from %s: %v", path, err)
+	}
+	return apps, nil
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and returns the positional arguments in order
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 func (s *StackAuditor) GetMetadata() plugin.PluginMetadata {
 	return plugin.PluginMetadata{
 		Name:    "StackAuditor",
@@ -183,12 +696,105 @@ func (s *StackAuditor) GetMetadata() plugin.PluginMetadata {
 			},
 			{
 				Name:     ChangeStackCmd,
-				HelpText: "Change an app's stack in the current space and restart the app",
+				HelpText: "Change an app's stack and restart the app",
 
 				UsageDetails: plugin.Usage{
+					Options: map[string]string{
+						"-org":              "org containing the app (default: the targeted org)",
+						"-space":            "space containing the app, so the app can be changed without targeting its space",
+						"-guid":             "GUID of the app, in place of its name, org and space",
+						"-from-file":        "change the apps listed in this audit-stack csv or json output, or in <org>/<space>/<app> lines (- for stdin)",
+						"-canary":           "migrate this many apps, or this percentage of them, first, then waves twice as large, pausing when a wave fails too often",
+						"-max-failure-rate": "percentage of apps in a wave that may fail before the run pauses for confirmation (default 0)",
+						"-parallel":         "change up to this many apps at once in a bulk run (default 1)",
+						"-max-per-org":      "change at most this many apps of the same org at once",
+						"-max-per-space":    "change at most this many apps of the same space at once",
+						"-policy":           "refuse stack changes that this migration policy file does not allow",
+						"-wait-in-flight":   "wait up to this long (e.g. 10m) for an app's active deployments and staging builds to finish instead of skipping the app",
+						"-estimate":         "change nothing, and estimate how long the run takes and the extra memory its restages need in each org",
+						"-verify-duration":  "watch the app's instances for this long after restaging (e.g. 5m) and roll back if any crash",
+						"-probe-url":        "during verification, also request this URL and roll back if it does not return the expected status",
+						"-probe-status":     fmt.Sprintf("HTTP status expected from the probe URL (default %d)", changer.DefaultProbeStatusCode),
+						"-journal":          "record each app's original stack, state and droplet and every completed step to this file",
+						"-resume":           "finish the planned and interrupted migrations recorded in this journal",
+						"-revert":           "return the apps changed in this journal to their original stack, droplet and state",
+						"-pre-hook":         "run this executable before each app's stack change; a non-zero exit skips the app",
+						"-post-hook":        "run this executable after each app's stack change",
+						"-window":           "only start app migrations inside this recurring window, e.g. \"Sat 02:00-06:00\", pausing outside it; runs changing more than one app need --journal",
+						"-timezone":         "timezone of the window, e.g. Europe/Berlin (default: local time)",
+						"-log-dir":          "directory for the staging output of failed restages (default: the system temp directory)",
+						"-json":             "output the result of each app's stack change in json format",
+					},
 					Usage: ChangeStackUsage,
 				},
 			},
+			{
+				Name:     StackPlanCmd,
+				HelpText: "Write an editable plan that migrates every app on a stack in waves",
+
+				UsageDetails: plugin.Usage{
+					Options: map[string]string{
+						"-from":            "stack the apps are migrated from",
+						"-to":              "stack the apps are migrated to",
+						"-strategy":        "restage strategy of each wave: rolling (default) or restart",
+						"-verify-duration": "verification window of each wave (e.g. 5m)",
+						"-probe-url":       "URL each wave requests during verification",
+						"-probe-status":    fmt.Sprintf("HTTP status expected from the probe URL (default %d)", changer.DefaultProbeStatusCode),
+					},
+					Usage: StackPlanUsage,
+				},
+			},
+			{
+				Name:     StackApplyCmd,
+				HelpText: "Change the stacks of the apps in a plan written by stack-plan, wave by wave",
+
+				UsageDetails: plugin.Usage{
+					Options: map[string]string{
+						"-max-failure-rate": "percentage of apps in a wave that may fail before the run pauses for confirmation",
+						"-parallel":         "change up to this many apps at once (default 1)",
+						"-max-per-org":      "change at most this many apps of the same org at once",
+						"-max-per-space":    "change at most this many apps of the same space at once",
+						"-policy":           "refuse stack changes that this migration policy file does not allow",
+						"-wait-in-flight":   "wait up to this long (e.g. 10m) for an app's active deployments and staging builds to finish instead of skipping the app",
+						"-estimate":         "change nothing, and estimate how long the run takes and the extra memory its restages need in each org",
+						"-journal":          "record each app's original stack, state and droplet and every completed step to this file",
+						"-pre-hook":         "run this executable before each app's stack change; a non-zero exit skips the app",
+						"-post-hook":        "run this executable after each app's stack change",
+						"-window":           "only start app migrations inside this recurring window, e.g. \"Sat 02:00-06:00\", pausing outside it; runs changing more than one app need --journal",
+						"-timezone":         "timezone of the window, e.g. Europe/Berlin (default: local time)",
+						"-log-dir":          "directory for the staging output of failed restages (default: the system temp directory)",
+						"-json":             "output the result of each app's stack change in json format",
+					},
+					Usage: StackApplyUsage,
+				},
+			},
+			{
+				Name:     RollbackStackCmd,
+				HelpText: "Return an app to its droplet on the previous stack and restart the app",
+
+				UsageDetails: plugin.Usage{
+					Options: map[string]string{
+						"-org":   "org containing the app (default: the targeted org)",
+						"-space": "space containing the app, so the app can be rolled back without targeting its space",
+						"-guid":  "GUID of the app, in place of its name, org and space",
+					},
+					Usage: RollbackStackUsage,
+				},
+			},
+			{
+				Name:     RevertStackCmd,
+				HelpText: "Return a migrated app to the stack, droplet and state it had before change-stack, as recorded on the app or in a journal",
+
+				UsageDetails: plugin.Usage{
+					Options: map[string]string{
+						"-org":     "org containing the app (default: the targeted org)",
+						"-space":   "space containing the app, so the app can be reverted without targeting its space",
+						"-guid":    "GUID of the app, in place of its name, org and space",
+						"-journal": "read the app's original stack, droplet and state from this change-stack journal",
+					},
+					Usage: RevertStackUsage,
+				},
+			},
 		},
 	}
 }
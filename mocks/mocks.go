@@ -15,19 +15,31 @@ import (
 
 //go:generate mockgen -package mocks -destination cli_connection.go code.cloudfoundry.org/cli/plugin CliConnection
 var (
-	StackAName = "stackA"
-	StackBName = "stackB"
-	StackAGuid = "stackAGuid"
-	StackBGuid = "stackBGuid"
-	StackEName = "stackE"
-	StackEGuid = "stackEGuid"
-	AppAName   = "appA"
-	AppBName   = "appB"
-	SpaceGuid  = "commonSpaceGuid"
-	SpaceName  = "commonSpace"
+	StackAName   = "stackA"
+	StackBName   = "stackB"
+	StackAGuid   = "stackAGuid"
+	StackBGuid   = "stackBGuid"
+	StackEName   = "stackE"
+	StackEGuid   = "stackEGuid"
+	AppAName     = "appA"
+	AppBName     = "appB"
+	AppAGuid     = "appAGuid"
+	AppBGuid     = "appBGuid"
+	SpaceGuid    = "commonSpaceGuid"
+	SpaceName    = "commonSpace"
+	OrgGuid      = "commonOrgGuid"
+	OrgQuotaGuid = "commonOrgQuotaGuid"
 )
 
 func SetupMockCliConnection(mockCtrl *gomock.Controller) *MockCliConnection {
+	mockConnection := NewMockCliConnection(mockCtrl)
+	ExpectDefaultResponses(mockConnection)
+	return mockConnection
+}
+
+// ExpectDefaultResponses registers the fixture responses shared by every
+// test. Expectations registered before calling it take precedence.
+func ExpectDefaultResponses(mockConnection *MockCliConnection) {
 	apps, err := FileToString("apps.json")
 	Expect(err).ToNot(HaveOccurred())
 
@@ -37,13 +49,69 @@ func SetupMockCliConnection(mockCtrl *gomock.Controller) *MockCliConnection {
 	appB, err := FileToString("appB.json")
 	Expect(err).ToNot(HaveOccurred())
 
+	appAByGuid, err := FileToString("appAByGuid.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appBByGuid, err := FileToString("appBByGuid.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appAProcesses, err := FileToString("appAProcesses.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appBProcesses, err := FileToString("appBProcesses.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appASidecars, err := FileToString("appASidecars.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	noSidecars, err := FileToString("emptyListV3.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appADroplet, err := FileToString("appADroplet.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appBDroplet, err := FileToString("appBDroplet.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appADroplets, err := FileToString("appADroplets.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	appBDroplets, err := FileToString("appBDroplets.json")
+	Expect(err).ToNot(HaveOccurred())
+
 	spaces, err := FileToString("spaces.json")
 	Expect(err).ToNot(HaveOccurred())
 
 	buildpacks, err := FileToString("buildpacks.json")
 	Expect(err).ToNot(HaveOccurred())
 
-	mockConnection := NewMockCliConnection(mockCtrl)
+	commonSpace, err := FileToString("commonSpaceV3.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	readyPackages, err := FileToString("readyPackages.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	emptyList, err := FileToString("emptyListV3.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	space, err := FileToString("commonSpace.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	org, err := FileToString("commonOrg.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	orgQuota, err := FileToString("commonOrgQuota.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	orgUsage, err := FileToString("commonOrgUsage.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	allSpaces, err := FileToString("allSpacesV3.json")
+	Expect(err).ToNot(HaveOccurred())
+
+	orgsByName, err := FileToString("commonOrgsV3.json")
+	Expect(err).ToNot(HaveOccurred())
+
 	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps?per_page=%s", cf.V3ResultsPerPage)).Return(
 		apps, nil).AnyTimes()
 
@@ -55,10 +123,54 @@ func SetupMockCliConnection(mockCtrl *gomock.Controller) *MockCliConnection {
 		appB,
 		nil).AnyTimes()
 
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppAGuid).Return(
+		appAByGuid,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+AppBGuid).Return(
+		appBByGuid,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/processes?per_page=%s", AppAGuid, cf.V3ResultsPerPage)).Return(
+		appAProcesses,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/processes?per_page=%s", AppBGuid, cf.V3ResultsPerPage)).Return(
+		appBProcesses,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/sidecars?per_page=%s", AppAGuid, cf.V3ResultsPerPage)).Return(
+		appASidecars,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/sidecars?per_page=%s", AppBGuid, cf.V3ResultsPerPage)).Return(
+		noSidecars,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/droplets/current", AppAGuid)).Return(
+		appADroplet,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/droplets/current", AppBGuid)).Return(
+		appBDroplet,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/droplets?states=STAGED&order_by=-created_at&per_page=%s", AppAGuid, cf.V3ResultsPerPage)).Return(
+		appADroplets,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/droplets?states=STAGED&order_by=-created_at&per_page=%s", AppBGuid, cf.V3ResultsPerPage)).Return(
+		appBDroplets,
+		nil).AnyTimes()
+
 	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v2/spaces?results-per-page=%s", cf.V2ResultsPerPage)).Return(
 		spaces,
 		nil).AnyTimes()
 
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/spaces?guids=%s&include=organization", SpaceGuid)).Return(
+		commonSpace,
+		nil).AnyTimes()
+
 	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("stack", "--guid", StackAName).Return(
 		[]string{
 			StackAGuid,
@@ -94,9 +206,57 @@ func SetupMockCliConnection(mockCtrl *gomock.Controller) *MockCliConnection {
 			},
 		}, nil).AnyTimes()
 
-	SetCurrentOrgAndSpace(mockConnection, "commonOrg", SpaceName, SpaceGuid)
+	for _, appGuid := range []string{AppAGuid, AppBGuid} {
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps/"+appGuid, "-X", "PATCH", HasPrefix(`-d={"metadata":`)).Return(
+			[]string{},
+			nil).AnyTimes()
 
-	return mockConnection
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/apps/%s/packages?order_by=-created_at&per_page=1", appGuid)).Return(
+			readyPackages,
+			nil).AnyTimes()
+
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/deployments?app_guids=%s&status_values=ACTIVE", appGuid)).Return(
+			emptyList,
+			nil).AnyTimes()
+
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/builds?app_guids=%s&states=STAGING", appGuid)).Return(
+			emptyList,
+			nil).AnyTimes()
+
+		mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/builds?app_guids=%s&states=STAGED&order_by=-created_at&per_page=5", appGuid)).Return(
+			emptyList,
+			nil).AnyTimes()
+	}
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/spaces?per_page=%s", cf.V3ResultsPerPage)).Return(
+		allSpaces,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/spaces/"+SpaceGuid).Return(
+		space,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations/"+OrgGuid).Return(
+		org,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organization_quotas/"+OrgQuotaGuid).Return(
+		orgQuota,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations/"+OrgGuid+"/usage_summary").Return(
+		orgUsage,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/organizations?names=commonOrg").Return(
+		orgsByName,
+		nil).AnyTimes()
+
+	mockConnection.EXPECT().CliCommandWithoutTerminalOutput("curl", fmt.Sprintf("/v3/spaces?names=%s&organization_guids=%s", SpaceName, OrgGuid)).Return(
+		commonSpace,
+		nil).AnyTimes()
+
+	SetCurrentOrgAndSpace(mockConnection, "commonOrg", SpaceName, SpaceGuid)
 }
 
 func SetCurrentOrgAndSpace(mockConnection *MockCliConnection, org string, space string, spaceGuid string) {
@@ -124,3 +284,19 @@ func FileToString(fileName string) ([]string, error) {
 
 	return strings.Split(string(buf), "\n"), nil
 }
+
+type prefixMatcher string
+
+// HasPrefix matches string arguments that start with prefix
+func HasPrefix(prefix string) gomock.Matcher {
+	return prefixMatcher(prefix)
+}
+
+func (p prefixMatcher) Matches(x interface{}) bool {
+	s, ok := x.(string)
+	return ok && strings.HasPrefix(s, string(p))
+}
+
+func (p prefixMatcher) String() string {
+	return fmt.Sprintf("has prefix %q", string(p))
+}
@@ -0,0 +1,226 @@
+package planner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PlanningMsg              = "Planning the migration of apps from Stack %s to %s...\n\n"
+	StrategyRolling          = "rolling"
+	StrategyRestart          = "restart"
+	NoAppsOnStackError       = "no apps are on stack %s"
+	SameStackError           = "the stack to migrate from and to must differ, got %s"
+	ErrorReadingPlan         = "problem reading plan %s: %v"
+	InvalidPlanError         = "invalid plan %s: %s"
+	MissingStacksReason      = "from and to are required"
+	NoWavesReason            = "it has no waves"
+	EmptyWaveReason          = "wave %d has no apps"
+	IncompleteAppReason      = "app %d of wave %d needs an org, space and name"
+	DuplicateAppReason       = "%s/%s/%s is in more than one wave"
+	InvalidVerifyReason      = "wave %d has an invalid verify_duration %q"
+	InvalidStatusReason      = "wave %d has an invalid probe_status %d"
+	ProbeWithoutVerifyReason = "wave %d has a probe_url but no verify_duration"
+	InvalidStrategyReason    = "wave %d has an unknown strategy %q, expected rolling or restart"
+	ExcludedAppsComment      = "# apps excluded from migration by annotation are listed under excluded and are not changed\n"
+	defaultProbeStatus       = 200
+	minimumProbeStatus       = 100
+	maximumProbeStatus       = 599
+)
+
+// Plan is an editable description of a migration: the apps to change, in
+// waves that run in order, and how each wave is restaged and verified
+type Plan struct {
+	From     string `yaml:"from"`
+	To       string `yaml:"to"`
+	Waves    []Wave `yaml:"waves"`
+	Excluded []App  `yaml:"excluded,omitempty"`
+}
+
+type Wave struct {
+	Name           string `yaml:"name"`
+	Strategy       string `yaml:"strategy"`
+	VerifyDuration string `yaml:"verify_duration,omitempty"`
+	ProbeURL       string `yaml:"probe_url,omitempty"`
+	ProbeStatus    int    `yaml:"probe_status,omitempty"`
+	Apps           []App  `yaml:"apps"`
+}
+
+type App struct {
+	Org    string `yaml:"org"`
+	Space  string `yaml:"space"`
+	Name   string `yaml:"name"`
+	Reason string `yaml:"reason,omitempty"`
+}
+
+// Verification is the verification window of the wave, zero when the wave
+// is not verified
+func (w Wave) Verification() time.Duration {
+	d, _ := time.ParseDuration(w.VerifyDuration)
+	return d
+}
+
+type Planner struct {
+	CF cf.CF
+}
+
+// Plan puts every app on the from stack into a plan with one wave per org.
+// Each wave starts with the given defaults. Apps that opt out of migration
+// are listed as excluded instead.
+func (p *Planner) Plan(from, to string, defaults Wave) (Plan, error) {
+	if from == to {
+		return Plan{}, fmt.Errorf(SameStackError, from)
+	}
+
+	fmt.Fprintf(os.Stderr, PlanningMsg, from, to)
+	if _, err := p.CF.GetStackGUID(to); err != nil {
+		return Plan{}, err
+	}
+
+	apps, err := p.CF.GetAppsAndStacks()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	sort.SliceStable(apps, func(i, j int) bool {
+		if apps[i].Org != apps[j].Org {
+			return apps[i].Org < apps[j].Org
+		}
+		if apps[i].Space != apps[j].Space {
+			return apps[i].Space < apps[j].Space
+		}
+		return apps[i].Name < apps[j].Name
+	})
+
+	plan := Plan{From: from, To: to}
+	for _, app := range apps {
+		if app.Stack != from {
+			continue
+		}
+
+		entry := App{Org: app.Org, Space: app.Space, Name: app.Name}
+		if app.SkipReason != "" {
+			entry.Reason = app.SkipReason
+			plan.Excluded = append(plan.Excluded, entry)
+			continue
+		}
+
+		if len(plan.Waves) == 0 || plan.Waves[len(plan.Waves)-1].Name != app.Org {
+			wave := defaults
+			wave.Name = app.Org
+			wave.Apps = nil
+			plan.Waves = append(plan.Waves, wave)
+		}
+		wave := &plan.Waves[len(plan.Waves)-1]
+		wave.Apps = append(wave.Apps, entry)
+	}
+
+	if len(plan.Waves) == 0 && len(plan.Excluded) == 0 {
+		return Plan{}, fmt.Errorf(NoAppsOnStackError, from)
+	}
+
+	return plan, nil
+}
+
+// YAML renders the plan for review and editing
+func (p Plan) YAML() (string, error) {
+	out, err := yaml.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	if len(p.Excluded) > 0 {
+		return ExcludedAppsComment + string(out), nil
+	}
+	return string(out), nil
+}
+
+// ReadPlan loads and validates a plan written by Plan and possibly edited
+// since
+func ReadPlan(path string) (Plan, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf(ErrorReadingPlan, path, err)
+	}
+
+	var plan Plan
+	if err := yaml.UnmarshalStrict(buf, &plan); err != nil {
+		return Plan{}, fmt.Errorf(ErrorReadingPlan, path, err)
+	}
+
+	if reason := plan.validate(); reason != "" {
+		return Plan{}, fmt.Errorf(InvalidPlanError, path, reason)
+	}
+	return plan, nil
+}
+
+func (p *Plan) validate() string {
+	if p.From == "" || p.To == "" {
+		return MissingStacksReason
+	}
+	if p.From == p.To {
+		return fmt.Sprintf(SameStackError, p.From)
+	}
+	if len(p.Waves) == 0 {
+		return NoWavesReason
+	}
+
+	seen := map[App]bool{}
+	for i := range p.Waves {
+		wave := &p.Waves[i]
+		if len(wave.Apps) == 0 {
+			return fmt.Sprintf(EmptyWaveReason, i+1)
+		}
+
+		switch wave.Strategy {
+		case "":
+			wave.Strategy = StrategyRolling
+		case StrategyRolling, StrategyRestart:
+		default:
+			return fmt.Sprintf(InvalidStrategyReason, i+1, wave.Strategy)
+		}
+
+		if wave.VerifyDuration != "" {
+			if d, err := time.ParseDuration(wave.VerifyDuration); err != nil || d < 0 {
+				return fmt.Sprintf(InvalidVerifyReason, i+1, wave.VerifyDuration)
+			}
+		}
+		if wave.ProbeURL != "" && wave.Verification() == 0 {
+			return fmt.Sprintf(ProbeWithoutVerifyReason, i+1)
+		}
+		if wave.ProbeStatus == 0 {
+			wave.ProbeStatus = defaultProbeStatus
+		}
+		if wave.ProbeStatus < minimumProbeStatus || wave.ProbeStatus > maximumProbeStatus {
+			return fmt.Sprintf(InvalidStatusReason, i+1, wave.ProbeStatus)
+		}
+
+		for j, app := range wave.Apps {
+			if app.Org == "" || app.Space == "" || app.Name == "" {
+				return fmt.Sprintf(IncompleteAppReason, j+1, i+1)
+			}
+
+			key := App{Org: app.Org, Space: app.Space, Name: app.Name}
+			if seen[key] {
+				return fmt.Sprintf(DuplicateAppReason, app.Org, app.Space, app.Name)
+			}
+			seen[key] = true
+		}
+	}
+	return ""
+}
+
+// Size is the number of apps the plan changes
+func (p Plan) Size() int {
+	size := 0
+	for _, wave := range p.Waves {
+		size += len(wave.Apps)
+	}
+	return size
+}
@@ -0,0 +1,13 @@
+package planner_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlanner(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Planner Suite")
+}
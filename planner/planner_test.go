@@ -0,0 +1,155 @@
+package planner_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/stack-auditor/cf"
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/planner"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	OrgName    = "commonOrg"
+	SpaceName  = "commonSpace"
+	AppAName   = "appA"
+	AppBName   = "appB"
+	StackAName = "stackA"
+	StackBName = "stackB"
+	StackEName = "stackE"
+	AppASkip   = "app annotation: vendor certification pending"
+)
+
+var _ = Describe("Planner", func() {
+	var (
+		mockCtrl       *gomock.Controller
+		mockConnection *mocks.MockCliConnection
+		p              planner.Planner
+		dir            string
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockConnection = mocks.SetupMockCliConnection(mockCtrl)
+		dir = GinkgoT().TempDir()
+
+		p = planner.Planner{
+			CF: cf.CF{
+				Conn: mockConnection,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	writePlan := func(contents string) string {
+		path := filepath.Join(dir, "plan.yml")
+		Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		return path
+	}
+
+	When("planning a migration", func() {
+		It("puts the apps on the from stack into a wave per org with the defaults", func() {
+			plan, err := p.Plan(StackBName, StackAName, planner.Wave{Strategy: planner.StrategyRestart, VerifyDuration: "5m0s"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plan.From).To(Equal(StackBName))
+			Expect(plan.To).To(Equal(StackAName))
+			Expect(plan.Waves).To(Equal([]planner.Wave{{
+				Name:           OrgName,
+				Strategy:       planner.StrategyRestart,
+				VerifyDuration: "5m0s",
+				Apps:           []planner.App{{Org: OrgName, Space: SpaceName, Name: AppBName}},
+			}}))
+			Expect(plan.Excluded).To(BeEmpty())
+		})
+
+		It("lists apps that opt out of migration as excluded", func() {
+			plan, err := p.Plan(StackAName, StackBName, planner.Wave{Strategy: planner.StrategyRolling})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plan.Waves).To(BeEmpty())
+			Expect(plan.Excluded).To(Equal([]planner.App{{Org: OrgName, Space: SpaceName, Name: AppAName, Reason: AppASkip}}))
+
+			out, err := plan.YAML()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out).To(HavePrefix(planner.ExcludedAppsComment))
+		})
+
+		It("fails when no app is on the from stack", func() {
+			_, err := p.Plan(StackEName, StackAName, planner.Wave{})
+			Expect(err).To(MatchError(fmt.Sprintf(planner.NoAppsOnStackError, StackEName)))
+		})
+
+		It("fails when the target stack does not exist", func() {
+			_, err := p.Plan(StackBName, "missingStack", planner.Wave{})
+			Expect(err).To(MatchError("missingStack is not a valid stack"))
+		})
+	})
+
+	When("reading a plan", func() {
+		It("reads back the plan it wrote", func() {
+			plan, err := p.Plan(StackBName, StackAName, planner.Wave{Strategy: planner.StrategyRolling, ProbeURL: "https://appB.example.com", ProbeStatus: 204, VerifyDuration: "1m0s"})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := plan.YAML()
+			Expect(err).NotTo(HaveOccurred())
+
+			read, err := planner.ReadPlan(writePlan(out))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(read).To(Equal(plan))
+			Expect(read.Size()).To(Equal(1))
+		})
+
+		It("fills in the default strategy and probe status", func() {
+			path := writePlan(`from: stackA
+to: stackB
+waves:
+- name: first
+  apps:
+  - {org: commonOrg, space: commonSpace, name: appA}
+`)
+			plan, err := planner.ReadPlan(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Waves[0].Strategy).To(Equal(planner.StrategyRolling))
+			Expect(plan.Waves[0].ProbeStatus).To(Equal(200))
+			Expect(plan.Waves[0].Verification()).To(BeZero())
+		})
+
+		DescribeTable("rejects plans that cannot be applied",
+			func(contents, reason string) {
+				path := writePlan(contents)
+				_, err := planner.ReadPlan(path)
+				Expect(err).To(MatchError(fmt.Sprintf(planner.InvalidPlanError, path, reason)))
+			},
+			Entry("without stacks", "waves: []\n", planner.MissingStacksReason),
+			Entry("without waves", "from: stackA\nto: stackB\n", planner.NoWavesReason),
+			Entry("with an empty wave", "from: stackA\nto: stackB\nwaves:\n- name: first\n", fmt.Sprintf(planner.EmptyWaveReason, 1)),
+			Entry("with an unknown strategy",
+				"from: stackA\nto: stackB\nwaves:\n- name: first\n  strategy: blue-green\n  apps:\n  - {org: o, space: s, name: a}\n",
+				fmt.Sprintf(planner.InvalidStrategyReason, 1, "blue-green")),
+			Entry("with an invalid verify duration",
+				"from: stackA\nto: stackB\nwaves:\n- name: first\n  verify_duration: soon\n  apps:\n  - {org: o, space: s, name: a}\n",
+				fmt.Sprintf(planner.InvalidVerifyReason, 1, "soon")),
+			Entry("with an app missing its space",
+				"from: stackA\nto: stackB\nwaves:\n- name: first\n  apps:\n  - {org: o, name: a}\n",
+				fmt.Sprintf(planner.IncompleteAppReason, 1, 1)),
+			Entry("with an app in two waves",
+				"from: stackA\nto: stackB\nwaves:\n- name: first\n  apps:\n  - {org: o, space: s, name: a}\n- name: second\n  apps:\n  - {org: o, space: s, name: a}\n",
+				fmt.Sprintf(planner.DuplicateAppReason, "o", "s", "a")),
+		)
+
+		It("rejects unknown fields so typos are not silently ignored", func() {
+			path := writePlan("from: stackA\nto: stackB\nwaves:\n- name: first\n  stratgy: restart\n  apps:\n  - {org: o, space: s, name: a}\n")
+			_, err := planner.ReadPlan(path)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("stratgy"))
+		})
+	})
+})
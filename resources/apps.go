@@ -33,8 +33,10 @@ type V3App struct {
 	Name      string `json:"name"`
 	State     string `json:"state"`
 	Lifecycle struct {
+		Type string `json:"type"`
 		Data struct {
-			Stack string `json:"stack"`
+			Buildpacks []string `json:"buildpacks"`
+			Stack      string   `json:"stack"`
 		} `json:"data"`
 	} `json:"lifecycle"`
 	Relationships struct {
@@ -44,7 +46,8 @@ type V3App struct {
 			} `json:"data"`
 		} `json:"space"`
 	} `json:"relationships"`
-	Links struct {
+	Metadata Metadata `json:"metadata"`
+	Links    struct {
 		Self struct {
 			Href string `json:"href"`
 		} `json:"self"`
@@ -1,18 +1,36 @@
 package resources
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
+const (
+	NoAppsListedError      = "no apps are listed"
+	InvalidAppLineError    = "line %d: expected <org>/<space>/<app>, got %q"
+	IncompleteAppListError = "app %d is missing its org, space or name"
+)
+
+// appListColumns are the csv columns that identify an app
+var appListColumns = []string{"org", "space", "name"}
+
 type App struct {
 	Org   string `json:"org"`
 	Space string `json:"space"`
 	Name  string `json:"name"`
 	Stack string `json:"stack"`
 	State string `json:"state"`
+	// The migration provenance and opt-out reason are left out of the csv,
+	// so it keeps the columns scripts rely on
+	MigratedFrom string `json:"migrated_from,omitempty"`
+	MigratedAt   string `json:"migrated_at,omitempty"`
+	SkipReason   string `json:"skip_migration,omitempty"`
 }
 
 type Apps []App
@@ -54,7 +72,17 @@ func (a Apps) CSV() (string, error) {
 }
 
 func (a App) String() string {
-	return fmt.Sprintf("%s/%s/%s %s %s", a.Org, a.Space, a.Name, a.Stack, a.State)
+	s := fmt.Sprintf("%s/%s/%s %s %s", a.Org, a.Space, a.Name, a.Stack, a.State)
+	switch {
+	case a.MigratedFrom != "" && a.MigratedAt != "":
+		s += fmt.Sprintf(" (migrated from %s at %s)", a.MigratedFrom, a.MigratedAt)
+	case a.MigratedFrom != "":
+		s += fmt.Sprintf(" (migrated from %s)", a.MigratedFrom)
+	}
+	if a.SkipReason != "" {
+		s += fmt.Sprintf(" (excluded from migration: %s)", a.SkipReason)
+	}
+	return s
 }
 
 func (a Apps) headers() []string {
@@ -82,3 +110,118 @@ func (a Apps) records() [][]string {
 
 	return result
 }
+
+// ReadApps reads a list of apps in any format audit-stack writes: the json
+// output, the csv output (whose columns may be reordered or added to), or
+// lines starting with <org>/<space>/<app> as in the plain text output.
+// Duplicate apps are listed once.
+func ReadApps(r io.Reader) (Apps, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(string(buf))
+	var apps Apps
+	switch {
+	case strings.HasPrefix(text, "["):
+		err = json.Unmarshal([]byte(text), &apps)
+	case isCSVHeader(strings.SplitN(text, "\n", 2)[0]):
+		apps, err = readAppsCSV(text)
+	default:
+		apps, err = readAppLines(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var unique Apps
+	seen := map[string]bool{}
+	for i, app := range apps {
+		if app.Org == "" || app.Space == "" || app.Name == "" {
+			return nil, fmt.Errorf(IncompleteAppListError, i+1)
+		}
+
+		key := app.Org + "/" + app.Space + "/" + app.Name
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, app)
+		}
+	}
+
+	if len(unique) == 0 {
+		return nil, errors.New(NoAppsListedError)
+	}
+	return unique, nil
+}
+
+func isCSVHeader(line string) bool {
+	headers := map[string]bool{}
+	for _, header := range strings.Split(line, ",") {
+		headers[strings.Trim(strings.TrimSpace(header), `"`)] = true
+	}
+	for _, column := range appListColumns {
+		if !headers[column] {
+			return false
+		}
+	}
+	return true
+}
+
+func readAppsCSV(text string) (Apps, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, header := range records[0] {
+		columns[strings.TrimSpace(header)] = i
+	}
+
+	field := func(record []string, header string) string {
+		i, ok := columns[header]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var apps Apps
+	for _, record := range records[1:] {
+		apps = append(apps, App{
+			Org:   field(record, "org"),
+			Space: field(record, "space"),
+			Name:  field(record, "name"),
+			Stack: field(record, "stack"),
+			State: field(record, "state"),
+		})
+	}
+	return apps, nil
+}
+
+func readAppLines(text string) (Apps, error) {
+	var apps Apps
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		parts := strings.SplitN(fields[0], "/", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf(InvalidAppLineError, line, scanner.Text())
+		}
+
+		app := App{Org: parts[0], Space: parts[1], Name: parts[2]}
+		if len(fields) > 1 {
+			app.Stack = fields[1]
+		}
+		apps = append(apps, app)
+	}
+	return apps, scanner.Err()
+}
@@ -0,0 +1,21 @@
+package resources
+
+import "time"
+
+// Partial structure of JSON when hitting the /v3/builds endpoint
+type BuildsJSON struct {
+	Builds []Build `json:"resources"`
+}
+
+type Build struct {
+	GUID      string    `json:"guid"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Duration returns how long the build took, from its creation to its last
+// update
+func (b Build) Duration() time.Duration {
+	return b.UpdatedAt.Sub(b.CreatedAt)
+}
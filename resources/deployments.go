@@ -0,0 +1,14 @@
+package resources
+
+// Partial structure of JSON when hitting the /v3/deployments endpoint
+type DeploymentsJSON struct {
+	Deployments []Deployment `json:"resources"`
+}
+
+type Deployment struct {
+	GUID   string `json:"guid"`
+	Status struct {
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+	} `json:"status"`
+}
@@ -12,7 +12,9 @@ type DropletListJSON struct {
 		Last struct {
 			Href string `json:"href"`
 		} `json:"last"`
-		Next     interface{} `json:"next"`
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
 		Previous interface{} `json:"previous"`
 	} `json:"pagination"`
 	Resources []DropletJSON `json:"resources"`
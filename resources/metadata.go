@@ -0,0 +1,83 @@
+package resources
+
+import "time"
+
+// Labels and annotations that record the stack changes made by the plugin
+const (
+	MigratedFromLabel    = "stack-auditor.cloudfoundry.org/migrated-from"
+	MigratedAtAnnotation = "stack-auditor.cloudfoundry.org/migrated-at"
+	MigratedByAnnotation = "stack-auditor.cloudfoundry.org/migrated-by"
+
+	MigratedFromDropletAnnotation = "stack-auditor.cloudfoundry.org/migrated-from-droplet"
+	MigratedFromStateAnnotation   = "stack-auditor.cloudfoundry.org/migrated-from-state"
+)
+
+// Metadata holds the labels and annotations of a v3 resource. A nil value in
+// a PATCH request removes the key.
+type Metadata struct {
+	Labels      map[string]*string `json:"labels,omitempty"`
+	Annotations map[string]*string `json:"annotations,omitempty"`
+}
+
+func (m Metadata) Label(key string) string {
+	return deref(m.Labels[key])
+}
+
+func (m Metadata) Annotation(key string) string {
+	return deref(m.Annotations[key])
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Annotations with which app teams exclude an app, or every app in a space,
+// from bulk stack changes. The optional expiry is a date or RFC 3339 time.
+const (
+	SkipMigrationAnnotation       = "stack-auditor.cloudfoundry.org/skip-migration"
+	SkipMigrationReasonAnnotation = "stack-auditor.cloudfoundry.org/skip-migration-reason"
+	SkipMigrationUntilAnnotation  = "stack-auditor.cloudfoundry.org/skip-migration-until"
+	NoSkipReason                  = "no reason given"
+)
+
+// SkipMigration reports whether the resource is excluded from bulk stack
+// changes at the given time, and why
+func (m Metadata) SkipMigration(now time.Time) (bool, string) {
+	if m.Annotation(SkipMigrationAnnotation) != "true" {
+		return false, ""
+	}
+
+	reason := m.Annotation(SkipMigrationReasonAnnotation)
+	if reason == "" {
+		reason = NoSkipReason
+	}
+
+	until := m.Annotation(SkipMigrationUntilAnnotation)
+	if until == "" {
+		return true, reason
+	}
+
+	expiry, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		expiry, err = time.Parse("2006-01-02", until)
+	}
+	if err == nil && !now.Before(expiry) {
+		return false, ""
+	}
+	return true, reason + " (until " + until + ")"
+}
+
+// SkipReason returns why an app is excluded from bulk stack changes by its
+// own or its space's annotations, or "" if it is not
+func SkipReason(app, space Metadata, now time.Time) string {
+	if skip, reason := app.SkipMigration(now); skip {
+		return "app annotation: " + reason
+	}
+	if skip, reason := space.SkipMigration(now); skip {
+		return "space annotation: " + reason
+	}
+	return ""
+}
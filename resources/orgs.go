@@ -12,3 +12,16 @@ func (o Orgs) Map() map[string]string {
 	}
 	return m
 }
+
+// Partial structure of JSON when hitting the /v3/organizations endpoint
+type V3OrgsJSON struct {
+	Orgs []V3Org `json:"resources"`
+}
+
+type V3Org struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Relationships struct {
+		Quota QuotaRelationship `json:"quota"`
+	} `json:"relationships"`
+}
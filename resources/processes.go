@@ -0,0 +1,40 @@
+package resources
+
+// Partial structure of JSON when hitting the /v3/apps/:guid/processes endpoint
+type ProcessesJSON struct {
+	Pagination struct {
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Processes []Process `json:"resources"`
+}
+
+type Process struct {
+	GUID        string      `json:"guid"`
+	Type        string      `json:"type"`
+	Instances   int         `json:"instances"`
+	MemoryInMB  int         `json:"memory_in_mb"`
+	DiskInMB    int         `json:"disk_in_mb"`
+	HealthCheck HealthCheck `json:"health_check"`
+}
+
+type HealthCheck struct {
+	Type string `json:"type"`
+	Data struct {
+		Timeout           *int   `json:"timeout,omitempty"`
+		InvocationTimeout *int   `json:"invocation_timeout,omitempty"`
+		Endpoint          string `json:"endpoint,omitempty"`
+	} `json:"data"`
+}
+
+// Partial structure of JSON when hitting the /v3/processes/:guid/stats endpoint
+type ProcessStatsJSON struct {
+	Stats []ProcessInstanceStats `json:"resources"`
+}
+
+type ProcessInstanceStats struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	State string `json:"state"`
+}
@@ -0,0 +1,36 @@
+package resources
+
+// Partial structure of JSON when hitting the /v3/space_quotas and
+// /v3/organization_quotas endpoints
+type V3Quota struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+	Apps struct {
+		TotalMemoryInMB *int `json:"total_memory_in_mb"`
+	} `json:"apps"`
+}
+
+// Partial structure of JSON when hitting the usage_summary endpoint of a
+// space or organization
+type UsageSummaryJSON struct {
+	UsageSummary struct {
+		StartedInstances int `json:"started_instances"`
+		MemoryInMB       int `json:"memory_in_mb"`
+	} `json:"usage_summary"`
+}
+
+// QuotaRelationship is the optional quota applied to a space or organization
+type QuotaRelationship struct {
+	Data *struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// MemoryQuota is the memory limit of a space or organization quota and the
+// memory already in use against it. A nil LimitInMB means unlimited.
+type MemoryQuota struct {
+	Kind      string
+	Name      string
+	LimitInMB *int
+	UsedInMB  int
+}
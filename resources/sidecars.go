@@ -0,0 +1,19 @@
+package resources
+
+// Partial structure of JSON when hitting the /v3/apps/:guid/sidecars endpoint
+type SidecarsJSON struct {
+	Pagination struct {
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Sidecars []Sidecar `json:"resources"`
+}
+
+type Sidecar struct {
+	GUID         string   `json:"guid,omitempty"`
+	Name         string   `json:"name"`
+	Command      string   `json:"command"`
+	ProcessTypes []string `json:"process_types"`
+	MemoryInMB   int      `json:"memory_in_mb,omitempty"`
+}
@@ -30,3 +30,30 @@ func (s Spaces) MakeSpaceOrgAndNameMap() (map[string]string, map[string]string)
 	}
 	return spaceNameMap, spaceOrgMap
 }
+
+// Partial structure of JSON when hitting the /v3/spaces endpoint
+type V3SpacesJSON struct {
+	Pagination struct {
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"pagination"`
+	Spaces   []V3Space `json:"resources"`
+	Included struct {
+		Orgs []V3Org `json:"organizations"`
+	} `json:"included"`
+}
+
+type V3Space struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Relationships struct {
+		Organization struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"organization"`
+		Quota QuotaRelationship `json:"quota"`
+	} `json:"relationships"`
+	Metadata Metadata `json:"metadata"`
+}
@@ -37,3 +37,21 @@ func (ui *UIController) ConfirmDelete(stackName string) bool {
 	fmt.Fprintf(ui.OutputWriter, "failed to scan user input aborting\n")
 	return false
 }
+
+// ConfirmContinue asks whether a paused run should go on. Only typing
+// "continue" confirms, so a stray enter does not resume a failing migration.
+func (ui *UIController) ConfirmContinue(prompt string) bool {
+	defer ui.OutputWriter.Flush()
+	fmt.Fprintf(ui.OutputWriter, "%s If so, type continue [continue]\n>", prompt)
+	ui.OutputWriter.Flush()
+	if ui.Scanner.Scan() {
+		if strings.ToLower(strings.TrimSpace(ui.Scanner.Text())) == "continue" {
+			fmt.Fprintf(ui.OutputWriter, "Continuing...\n")
+			return true
+		}
+		fmt.Fprintf(ui.OutputWriter, "stopping the run\n")
+		return false
+	}
+	fmt.Fprintf(ui.OutputWriter, "failed to scan user input, stopping the run\n")
+	return false
+}
@@ -53,4 +53,29 @@ var _ = Describe("TerminalUI", func() {
 		})
 
 	})
+
+	When("confirming that a paused run continues", func() {
+		BeforeEach(func() {
+			outputBuffer.Reset()
+			inputBuffer.Reset()
+		})
+
+		It("returns true when the user types continue", func() {
+			inputBuffer.WriteString("continue\n")
+			Expect(uiController.ConfirmContinue("Continue migrating the remaining 3 apps?")).To(BeTrue())
+			Expect(outputBuffer.String()).To(ContainSubstring("Continue migrating the remaining 3 apps?"))
+			Expect(outputBuffer.String()).To(ContainSubstring("Continuing..."))
+		})
+
+		It("returns false when the user types anything else", func() {
+			inputBuffer.WriteString("y\n")
+			Expect(uiController.ConfirmContinue("Continue?")).To(BeFalse())
+			Expect(outputBuffer.String()).To(ContainSubstring("stopping the run"))
+		})
+
+		It("returns false when there is no user input", func() {
+			Expect(uiController.ConfirmContinue("Continue?")).To(BeFalse())
+			Expect(outputBuffer.String()).To(ContainSubstring("failed to scan user input"))
+		})
+	})
 })
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// FallbackConnection runs cf CLI commands over the plugin RPC connection
+// until Detached reports true, and in cf subprocesses after that. Ctrl-C
+// sends SIGINT to the cf CLI hosting the plugin, which exits and takes the
+// RPC server with it, so the commands that finish or revert the current
+// migration must not depend on it.
+type FallbackConnection struct {
+	plugin.CliConnection
+	Detached func() bool
+}
+
+func (c FallbackConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	if !c.Detached() {
+		return c.CliConnection.CliCommandWithoutTerminalOutput(args...)
+	}
+
+	cmd := exec.Command("cf", args...)
+	detach(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(output), "\n"), "\n"), nil
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// JSONLines is an append-only file holding one JSON value per line. Each
+// value is written in a single call and synced before Append returns, so
+// concurrent writers never interleave and a crash loses at most one line.
+type JSONLines struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenJSONLines opens path for appending, creating it with perm if needed
+func OpenJSONLines(path string, perm os.FileMode) (*JSONLines, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLines{file: file}, nil
+}
+
+func (l *JSONLines) Append(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *JSONLines) Close() error {
+	return l.file.Close()
+}
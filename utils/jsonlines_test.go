@@ -0,0 +1,48 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/stack-auditor/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONLines", func() {
+	It("appends each value as a line, keeping what the file already holds", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "lines.jsonl")
+		Expect(os.WriteFile(path, []byte("{\"n\":0}\n"), 0600)).To(Succeed())
+
+		lines, err := utils.OpenJSONLines(path, 0600)
+		Expect(err).NotTo(HaveOccurred())
+
+		var wg sync.WaitGroup
+		for n := 1; n <= 20; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				Expect(lines.Append(map[string]int{"n": n})).To(Succeed())
+			}(n)
+		}
+		wg.Wait()
+		Expect(lines.Close()).To(Succeed())
+
+		contents, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		written := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		Expect(written).To(HaveLen(21))
+
+		seen := map[int]bool{}
+		for _, line := range written {
+			var value map[string]int
+			Expect(json.Unmarshal([]byte(line), &value)).To(Succeed())
+			seen[value["n"]] = true
+		}
+		Expect(seen).To(HaveLen(21))
+	})
+})
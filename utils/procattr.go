@@ -0,0 +1,14 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in its own process group, so that the SIGINT a
+// terminal sends on Ctrl-C to the foreground group does not kill it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts cmd in its own process group, so that the Ctrl-C sent to
+// the console does not kill it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
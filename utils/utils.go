@@ -8,12 +8,24 @@ import (
 	"strings"
 )
 
+// Command runs executables in their own process group, so that they are
+// left to finish when the plugin is interrupted with Ctrl-C
 type Command struct {
 }
 
 func (c Command) Run(bin, dir string, quiet bool, args ...string) error {
+	return c.RunWithEnv(bin, dir, quiet, nil, args...)
+}
+
+// RunWithEnv runs bin with env added to the environment of the plugin,
+// which is left unchanged
+func (c Command) RunWithEnv(bin, dir string, quiet bool, env []string, args ...string) error {
 	cmd := exec.Command(bin, args...)
+	detach(cmd)
 	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	if quiet {
 		cmd.Stdout = io.Discard
 		cmd.Stderr = io.Discard
@@ -25,10 +37,20 @@ func (c Command) Run(bin, dir string, quiet bool, args ...string) error {
 }
 
 func (c Command) RunWithOutput(bin, dir string, quiet bool, args ...string) (string, error) {
+	return c.RunWithOutputEnv(bin, dir, quiet, nil, args...)
+}
+
+// RunWithOutputEnv runs bin with env added to the environment of the
+// plugin, and returns its combined output
+func (c Command) RunWithOutputEnv(bin, dir string, quiet bool, env []string, args ...string) (string, error) {
 	logs := &bytes.Buffer{}
 
 	cmd := exec.Command(bin, args...)
+	detach(cmd)
 	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	if quiet {
 		cmd.Stdout = io.MultiWriter(io.Discard, logs)
 		cmd.Stderr = io.MultiWriter(io.Discard, logs)
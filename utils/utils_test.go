@@ -0,0 +1,68 @@
+//go:build !windows
+
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/cloudfoundry/stack-auditor/mocks"
+	"github.com/cloudfoundry/stack-auditor/utils"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Utils", func() {
+	var binDir string
+
+	BeforeEach(func() {
+		binDir = GinkgoT().TempDir()
+		fakeCF := "#!/bin/sh\necho \"cf $*\"\necho \"pgid $(ps -o pgid= -p $$ | tr -d ' ')\"\n"
+		Expect(os.WriteFile(filepath.Join(binDir, "cf"), []byte(fakeCF), 0755)).To(Succeed())
+		GinkgoT().Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	})
+
+	It("runs commands in their own process group so Ctrl-C does not kill them", func() {
+		output, err := utils.Command{}.RunWithOutput("cf", ".", true, "restage", "appA")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("cf restage appA"))
+		match := regexp.MustCompile(`pgid (\d+)`).FindStringSubmatch(output)
+		Expect(match).To(HaveLen(2))
+		Expect(match[1]).NotTo(Equal(strconv.Itoa(syscall.Getpgrp())))
+	})
+
+	When("using a fallback connection", func() {
+		var (
+			mockCtrl *gomock.Controller
+			conn     *mocks.MockCliConnection
+			detached bool
+		)
+
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			conn = mocks.NewMockCliConnection(mockCtrl)
+			detached = false
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("uses the plugin connection until detached, then cf subprocesses", func() {
+			conn.EXPECT().CliCommandWithoutTerminalOutput("curl", "/v3/apps").Return([]string{"rpc"}, nil)
+			fallback := utils.FallbackConnection{CliConnection: conn, Detached: func() bool { return detached }}
+
+			Expect(fallback.CliCommandWithoutTerminalOutput("curl", "/v3/apps")).To(Equal([]string{"rpc"}))
+
+			detached = true
+			output, err := fallback.CliCommandWithoutTerminalOutput("curl", "/v3/apps", "-X", "POST")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output[0]).To(Equal("cf curl /v3/apps -X POST"))
+		})
+	})
+})